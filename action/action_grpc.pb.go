@@ -7,6 +7,7 @@ import (
 	grpc "google.golang.org/grpc"
 	codes "google.golang.org/grpc/codes"
 	status "google.golang.org/grpc/status"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
 )
 
 // This is a compile-time assertion to ensure that this generated file
@@ -105,6 +106,8 @@ var Gateway_ServiceDesc = grpc.ServiceDesc{
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type ProcessorClient interface {
 	ProcessActions(ctx context.Context, opts ...grpc.CallOption) (Processor_ProcessActionsClient, error)
+	// Describe lets a Processor declare which Actions it handles.
+	Describe(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*ProcessorInfo, error)
 }
 
 type processorClient struct {
@@ -146,11 +149,22 @@ func (x *processorProcessActionsClient) Recv() (*ProcessorResponse, error) {
 	return m, nil
 }
 
+func (c *processorClient) Describe(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*ProcessorInfo, error) {
+	out := new(ProcessorInfo)
+	err := c.cc.Invoke(ctx, "/event.Processor/Describe", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // ProcessorServer is the server API for Processor service.
 // All implementations must embed UnimplementedProcessorServer
 // for forward compatibility
 type ProcessorServer interface {
 	ProcessActions(Processor_ProcessActionsServer) error
+	// Describe lets a Processor declare which Actions it handles.
+	Describe(context.Context, *emptypb.Empty) (*ProcessorInfo, error)
 	mustEmbedUnimplementedProcessorServer()
 }
 
@@ -161,6 +175,9 @@ type UnimplementedProcessorServer struct {
 func (UnimplementedProcessorServer) ProcessActions(Processor_ProcessActionsServer) error {
 	return status.Errorf(codes.Unimplemented, "method ProcessActions not implemented")
 }
+func (UnimplementedProcessorServer) Describe(context.Context, *emptypb.Empty) (*ProcessorInfo, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Describe not implemented")
+}
 func (UnimplementedProcessorServer) mustEmbedUnimplementedProcessorServer() {}
 
 // UnsafeProcessorServer may be embedded to opt out of forward compatibility for this service.
@@ -200,13 +217,36 @@ func (x *processorProcessActionsServer) Recv() (*ProcessorRequest, error) {
 	return m, nil
 }
 
+func _Processor_Describe_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ProcessorServer).Describe(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/event.Processor/Describe",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ProcessorServer).Describe(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // Processor_ServiceDesc is the grpc.ServiceDesc for Processor service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
 var Processor_ServiceDesc = grpc.ServiceDesc{
 	ServiceName: "event.Processor",
 	HandlerType: (*ProcessorServer)(nil),
-	Methods:     []grpc.MethodDesc{},
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Describe",
+			Handler:    _Processor_Describe_Handler,
+		},
+	},
 	Streams: []grpc.StreamDesc{
 		{
 			StreamName:    "ProcessActions",
@@ -217,3 +257,127 @@ var Processor_ServiceDesc = grpc.ServiceDesc{
 	},
 	Metadata: "action.proto",
 }
+
+// ProcessorGatewayClient is the client API for ProcessorGateway service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ProcessorGatewayClient interface {
+	// Connect is ProcessActions with the Processor as the client. The roles of
+	// the messages are preserved, so the Processor still receives requests and
+	// sends responses. The first message must be a registration.
+	Connect(ctx context.Context, opts ...grpc.CallOption) (ProcessorGateway_ConnectClient, error)
+}
+
+type processorGatewayClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewProcessorGatewayClient(cc grpc.ClientConnInterface) ProcessorGatewayClient {
+	return &processorGatewayClient{cc}
+}
+
+func (c *processorGatewayClient) Connect(ctx context.Context, opts ...grpc.CallOption) (ProcessorGateway_ConnectClient, error) {
+	stream, err := c.cc.NewStream(ctx, &ProcessorGateway_ServiceDesc.Streams[0], "/event.ProcessorGateway/Connect", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &processorGatewayConnectClient{stream}
+	return x, nil
+}
+
+type ProcessorGateway_ConnectClient interface {
+	Send(*ProcessorResponse) error
+	Recv() (*ProcessorRequest, error)
+	grpc.ClientStream
+}
+
+type processorGatewayConnectClient struct {
+	grpc.ClientStream
+}
+
+func (x *processorGatewayConnectClient) Send(m *ProcessorResponse) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *processorGatewayConnectClient) Recv() (*ProcessorRequest, error) {
+	m := new(ProcessorRequest)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// ProcessorGatewayServer is the server API for ProcessorGateway service.
+// All implementations must embed UnimplementedProcessorGatewayServer
+// for forward compatibility
+type ProcessorGatewayServer interface {
+	// Connect is ProcessActions with the Processor as the client. The roles of
+	// the messages are preserved, so the Processor still receives requests and
+	// sends responses. The first message must be a registration.
+	Connect(ProcessorGateway_ConnectServer) error
+	mustEmbedUnimplementedProcessorGatewayServer()
+}
+
+// UnimplementedProcessorGatewayServer must be embedded to have forward compatible implementations.
+type UnimplementedProcessorGatewayServer struct {
+}
+
+func (UnimplementedProcessorGatewayServer) Connect(ProcessorGateway_ConnectServer) error {
+	return status.Errorf(codes.Unimplemented, "method Connect not implemented")
+}
+func (UnimplementedProcessorGatewayServer) mustEmbedUnimplementedProcessorGatewayServer() {}
+
+// UnsafeProcessorGatewayServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ProcessorGatewayServer will
+// result in compilation errors.
+type UnsafeProcessorGatewayServer interface {
+	mustEmbedUnimplementedProcessorGatewayServer()
+}
+
+func RegisterProcessorGatewayServer(s grpc.ServiceRegistrar, srv ProcessorGatewayServer) {
+	s.RegisterService(&ProcessorGateway_ServiceDesc, srv)
+}
+
+func _ProcessorGateway_Connect_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(ProcessorGatewayServer).Connect(&processorGatewayConnectServer{stream})
+}
+
+type ProcessorGateway_ConnectServer interface {
+	Send(*ProcessorRequest) error
+	Recv() (*ProcessorResponse, error)
+	grpc.ServerStream
+}
+
+type processorGatewayConnectServer struct {
+	grpc.ServerStream
+}
+
+func (x *processorGatewayConnectServer) Send(m *ProcessorRequest) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *processorGatewayConnectServer) Recv() (*ProcessorResponse, error) {
+	m := new(ProcessorResponse)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// ProcessorGateway_ServiceDesc is the grpc.ServiceDesc for ProcessorGateway service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var ProcessorGateway_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "event.ProcessorGateway",
+	HandlerType: (*ProcessorGatewayServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Connect",
+			Handler:       _ProcessorGateway_Connect_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "action.proto",
+}
@@ -0,0 +1,148 @@
+package action
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// NewAdminHandler exposes administrative operations on a Gateway over HTTP,
+// under the /admin/ path prefix:
+//
+//	GET /admin/routes/{type}/weights  how actions of the type are split
+//	PUT /admin/routes/{type}/weights  change how they're split, e.g. {"default": 95, "canary": 5}
+//
+//	GET    /admin/dead-letters                actions which failed to be processed, optionally ?type=
+//	DELETE /admin/dead-letters                purge them, optionally only those of ?type=
+//	GET    /admin/dead-letters/{id}           inspect one of them
+//	DELETE /admin/dead-letters/{id}           purge it
+//	POST   /admin/dead-letters/{id}/replay    send it again, optionally to {"processor": "host:port"}
+//
+// Replays are only sent to processors which are routed to or connected. The
+// handler doesn't authenticate its callers, so it must only be served where
+// operators, and nobody else, can reach it.
+func NewAdminHandler(s *Gateway) http.Handler {
+	r := mux.NewRouter()
+
+	weights := r.Path("/admin/routes/{type}/weights").Subrouter()
+	weights.Methods(http.MethodGet).HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ws, err := s.Weights(mux.Vars(req)["type"])
+		if err != nil {
+			writeProblem(w, newProblem(err))
+			return
+		}
+		writeJSON(w, http.StatusOK, ws)
+	})
+	weights.Methods(http.MethodPut).HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var ws map[string]int
+		err := json.NewDecoder(req.Body).Decode(&ws)
+		if err != nil {
+			writeProblem(w, newProblem(status.Errorf(codes.InvalidArgument, "invalid weights: %s", err)))
+			return
+		}
+
+		typeName := mux.Vars(req)["type"]
+		err = s.SetWeights(typeName, ws)
+		if err != nil {
+			writeProblem(w, newProblem(err))
+			return
+		}
+		zap.L().Info("changed split weights", zap.String("type", typeName), zap.Any("weights", ws))
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	deadLetters := r.Path("/admin/dead-letters").Subrouter()
+	deadLetters.Methods(http.MethodGet).HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		dls, err := s.DeadLetters(req.Context(), req.URL.Query().Get("type"))
+		if err != nil {
+			writeProblem(w, newProblem(err))
+			return
+		}
+		writeJSON(w, http.StatusOK, dls)
+	})
+	deadLetters.Methods(http.MethodDelete).HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		typeName := req.URL.Query().Get("type")
+		n, err := s.PurgeDeadLetters(req.Context(), typeName)
+		if err != nil {
+			writeProblem(w, newProblem(err))
+			return
+		}
+		zap.L().Info("purged dead letters", zap.String("type", typeName), zap.Int("count", n))
+		writeJSON(w, http.StatusOK, map[string]int{"purged": n})
+	})
+
+	deadLetter := r.Path("/admin/dead-letters/{id}").Subrouter()
+	deadLetter.Methods(http.MethodGet).HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		dl, err := s.DeadLetter(req.Context(), mux.Vars(req)["id"])
+		if err != nil {
+			writeProblem(w, newProblem(err))
+			return
+		}
+		writeJSON(w, http.StatusOK, dl)
+	})
+	deadLetter.Methods(http.MethodDelete).HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		id := mux.Vars(req)["id"]
+		err := s.PurgeDeadLetter(req.Context(), id)
+		if err != nil {
+			writeProblem(w, newProblem(err))
+			return
+		}
+		zap.L().Info("purged dead letter", zap.String("id", id))
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	r.Methods(http.MethodPost).Path("/admin/dead-letters/{id}/replay").HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var body struct {
+			Processor string `json:"processor"`
+		}
+		err := json.NewDecoder(req.Body).Decode(&body)
+		if err != nil && err != io.EOF {
+			writeProblem(w, newProblem(status.Errorf(codes.InvalidArgument, "invalid replay request: %s", err)))
+			return
+		}
+
+		resp, err := s.ReplayDeadLetter(req.Context(), mux.Vars(req)["id"], body.Processor)
+		if err != nil {
+			writeProblem(w, newProblem(err))
+			return
+		}
+
+		switch x := resp.GetBody().(type) {
+		case *ActionResponse_Content:
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			_, err = w.Write(x.Content)
+			if err != nil {
+				zap.L().Error("unexpected error when writing response body", zap.Error(err))
+			}
+		case *ActionResponse_WasProcessed:
+			w.WriteHeader(http.StatusNoContent)
+		case *ActionResponse_Accepted:
+			w.WriteHeader(http.StatusAccepted)
+		}
+	})
+
+	return r
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		zap.L().Error("unexpected error when encoding response body", zap.Error(err))
+		writeProblem(w, newProblem(status.Error(codes.Internal, err.Error())))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+
+	_, err = w.Write(b)
+	if err != nil {
+		zap.L().Error("unexpected error when writing response body", zap.Error(err))
+	}
+}
@@ -0,0 +1,46 @@
+package action
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"google.golang.org/grpc/backoff"
+)
+
+// backoffDelay computes the amount of time to wait before the given retry
+// attempt using the same exponential strategy as gRPC's connection backoff.
+func backoffDelay(cfg backoff.Config, retries int) time.Duration {
+	if retries == 0 {
+		return cfg.BaseDelay
+	}
+
+	delay, max := float64(cfg.BaseDelay), float64(cfg.MaxDelay)
+	for delay < max && retries > 0 {
+		delay *= cfg.Multiplier
+		retries--
+	}
+	if delay > max {
+		delay = max
+	}
+
+	// randomize delay so that multiple clients don't retry in lockstep
+	delay *= 1 + cfg.Jitter*(rand.Float64()*2-1)
+	if delay < 0 {
+		return 0
+	}
+	return time.Duration(delay)
+}
+
+// sleep waits for d to elapse, returning false if ctx is done first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
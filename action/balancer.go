@@ -0,0 +1,196 @@
+package action
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"sort"
+	"strconv"
+	"sync/atomic"
+)
+
+// Balancer picks which Endpoint of an EndpointGroup an Action is sent to.
+// Ejected endpoints are skipped unless every endpoint has been ejected.
+type Balancer interface {
+	// Pick returns the endpoint to send an action with the given key to,
+	// or nil if there are no endpoints.
+	Pick(key string) *Endpoint
+}
+
+// BalancerBuilder builds a Balancer for the given endpoints.
+type BalancerBuilder func(endpoints []*Endpoint) Balancer
+
+var balancers = map[string]BalancerBuilder{
+	"round_robin":     NewRoundRobinBalancer,
+	"least_loaded":    NewLeastLoadedBalancer,
+	"power_of_two":    NewPowerOfTwoBalancer,
+	"consistent_hash": NewConsistentHashBalancer,
+	"":                NewRoundRobinBalancer,
+}
+
+// LookupBalancer returns the BalancerBuilder registered under the given name.
+// The empty name refers to the default, round_robin.
+func LookupBalancer(name string) (BalancerBuilder, error) {
+	b, ok := balancers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown balancer: %s", name)
+	}
+	return b, nil
+}
+
+type roundRobinBalancer struct {
+	endpoints []*Endpoint
+	next      uint32
+}
+
+// NewRoundRobinBalancer cycles through the endpoints in order.
+func NewRoundRobinBalancer(endpoints []*Endpoint) Balancer {
+	return &roundRobinBalancer{endpoints: endpoints}
+}
+
+func (b *roundRobinBalancer) Pick(string) *Endpoint {
+	n := len(b.endpoints)
+	if n == 0 {
+		return nil
+	}
+
+	start := int(atomic.AddUint32(&b.next, 1))
+	for i := 0; i < n; i++ {
+		e := b.endpoints[(start+i)%n]
+		if e.Healthy() {
+			return e
+		}
+	}
+	return b.endpoints[start%n]
+}
+
+type leastLoadedBalancer struct {
+	endpoints []*Endpoint
+}
+
+// NewLeastLoadedBalancer picks the endpoint with the least in-flight actions.
+func NewLeastLoadedBalancer(endpoints []*Endpoint) Balancer {
+	return &leastLoadedBalancer{endpoints: endpoints}
+}
+
+func (b *leastLoadedBalancer) Pick(string) *Endpoint {
+	var least *Endpoint
+	for _, e := range b.endpoints {
+		if !e.Healthy() {
+			continue
+		}
+		if least == nil || e.InFlight() < least.InFlight() {
+			least = e
+		}
+	}
+	if least == nil && len(b.endpoints) > 0 {
+		return b.endpoints[rand.Intn(len(b.endpoints))]
+	}
+	return least
+}
+
+type powerOfTwoBalancer struct {
+	endpoints []*Endpoint
+}
+
+// NewPowerOfTwoBalancer picks two random endpoints and then the one of them
+// with the least in-flight actions.
+func NewPowerOfTwoBalancer(endpoints []*Endpoint) Balancer {
+	return &powerOfTwoBalancer{endpoints: endpoints}
+}
+
+func (b *powerOfTwoBalancer) Pick(string) *Endpoint {
+	n := len(b.endpoints)
+	switch n {
+	case 0:
+		return nil
+	case 1:
+		return b.endpoints[0]
+	}
+
+	i := rand.Intn(n)
+	j := rand.Intn(n - 1)
+	if j >= i {
+		j++
+	}
+
+	x, y := b.endpoints[i], b.endpoints[j]
+	switch {
+	case !x.Healthy() && y.Healthy():
+		return y
+	case x.Healthy() && !y.Healthy():
+		return x
+	case y.InFlight() < x.InFlight():
+		return y
+	default:
+		return x
+	}
+}
+
+// virtualNodes is the number of points each endpoint gets on the hash ring.
+const virtualNodes = 100
+
+type consistentHashBalancer struct {
+	endpoints []*Endpoint
+	ring      []ringNode
+}
+
+type ringNode struct {
+	hash     uint64
+	endpoint *Endpoint
+}
+
+// NewConsistentHashBalancer maps keys onto a hash ring of the endpoints, so
+// the same key keeps going to the same endpoint and only a fraction of keys
+// move when endpoints are added or removed. Actions without a key are sent
+// to a random endpoint.
+func NewConsistentHashBalancer(endpoints []*Endpoint) Balancer {
+	b := &consistentHashBalancer{
+		endpoints: endpoints,
+		ring:      make([]ringNode, 0, len(endpoints)*virtualNodes),
+	}
+
+	for _, e := range endpoints {
+		for i := 0; i < virtualNodes; i++ {
+			b.ring = append(b.ring, ringNode{
+				hash:     hashKey(e.Addr() + "#" + strconv.Itoa(i)),
+				endpoint: e,
+			})
+		}
+	}
+	sort.Slice(b.ring, func(i, j int) bool {
+		return b.ring[i].hash < b.ring[j].hash
+	})
+
+	return b
+}
+
+func (b *consistentHashBalancer) Pick(key string) *Endpoint {
+	n := len(b.ring)
+	if n == 0 {
+		return nil
+	}
+	if key == "" {
+		return b.endpoints[rand.Intn(len(b.endpoints))]
+	}
+
+	h := hashKey(key)
+	start := sort.Search(n, func(i int) bool {
+		return b.ring[i].hash >= h
+	})
+
+	// walk the ring past ejected endpoints so only their keys move
+	for i := 0; i < n; i++ {
+		e := b.ring[(start+i)%n].endpoint
+		if e.Healthy() {
+			return e
+		}
+	}
+	return b.ring[start%n].endpoint
+}
+
+func hashKey(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return h.Sum64()
+}
@@ -0,0 +1,633 @@
+package action
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// RoutesKey is the config key under which action type names are mapped to
+// the RouteConfig of the processors handling them. Since viper keys are case
+// insensitive, so are the action type names. Every route also registers its
+// action type name.
+const RoutesKey = "routes"
+
+// ProcessorsKey is the config key listing the addresses of processors which
+// aren't routed to statically. Instead, the action types they register with
+// at the start of their streams are routed to them for as long as the streams
+// stay open.
+const ProcessorsKey = "processors"
+
+// RoutingConfig configures how actions are routed to processors.
+type RoutingConfig struct {
+	Routes     map[string]RouteConfig
+	Processors []string
+}
+
+// DefaultGroup is the name of the group of processor endpoints a route
+// configures besides its named Groups.
+const DefaultGroup = "default"
+
+// RouteConfig configures the groups of processor endpoints an action type is
+// routed to. Actions are sent to the DefaultGroup, configured by the embedded
+// GroupConfig, unless they're fanned out.
+type RouteConfig struct {
+	GroupConfig `mapstructure:",squash"`
+
+	// PartitionKeyPath is the dot separated path of the JSON payload field
+	// which actions without a partition key are partitioned by.
+	PartitionKeyPath string
+
+	// Groups are named groups of processor endpoints besides the DefaultGroup.
+	Groups map[string]GroupConfig
+
+	// Pipeline sends actions through the routes of other action types,
+	// instead of any groups, each step's content becoming the payload of
+	// the next step's action.
+	Pipeline []StepConfig
+
+	// Rules route actions to groups by their content, the first matching
+	// rule winning. Actions matching none are routed as usual.
+	Rules []RuleConfig
+
+	FanOut FanOutConfig
+	Shadow ShadowConfig
+	Split  SplitConfig
+	Retry  RetryConfig
+}
+
+// RetryConfig configures retrying actions which failed to be processed, with
+// exponential backoff between attempts.
+type RetryConfig struct {
+	// MaxAttempts includes the first attempt, so only values above 1 retry
+	MaxAttempts int
+
+	// BaseDelay is the delay before the first retry, which is multiplied by
+	// Multiplier for every further retry up to MaxDelay, and randomized by
+	// Jitter. The defaults are 100ms, 1.6, 5s and 0.2.
+	BaseDelay  time.Duration
+	Multiplier float64
+	MaxDelay   time.Duration
+	Jitter     float64
+
+	// RetryOn are the names of the gRPC status codes of the errors which are
+	// retried, by default Unavailable, ResourceExhausted and DeadlineExceeded.
+	RetryOn []string
+
+	// AttemptTimeout bounds how long each attempt may take, on top of the
+	// deadline of the action itself.
+	AttemptTimeout time.Duration
+}
+
+// StepConfig configures a step of a pipeline.
+type StepConfig struct {
+	// Type is the name of the action type whose route the step is sent to
+	Type string
+
+	// Timeout bounds how long the step may take, on top of the deadline
+	// of the action itself.
+	Timeout time.Duration
+
+	// OnError is either fail, the default, which fails the whole pipeline,
+	// or skip, which passes the payload on to the next step as is.
+	OnError string
+
+	// Compensate is the name of the action type sent to undo the step when
+	// a later step fails the pipeline. Its payload is the content the step
+	// responded with, or the step's own payload if it responded without.
+	Compensate string
+}
+
+// RuleConfig routes the actions for which a CEL expression evaluates to true
+// to a group, e.g. {when: 'payload.region == "eu"', group: eu}.
+type RuleConfig struct {
+	When  string
+	Group string
+}
+
+// GroupConfig configures a group of replicas of a processor.
+type GroupConfig struct {
+	// Endpoints are the addresses of the processor replicas. When empty for
+	// the DefaultGroup, the action type is routed to the processors which
+	// registered it.
+	Endpoints []string
+
+	// Balancer is the name of the balancer used across Endpoints,
+	// see LookupBalancer.
+	Balancer string
+
+	Stream StreamConfig `mapstructure:",squash"`
+}
+
+// SplitConfig configures splitting the actions between groups by weight,
+// e.g. for canary releases of processors.
+type SplitConfig struct {
+	// Weights maps the names of groups to their share of the actions
+	Weights map[string]int
+
+	// Sticky sends actions with the same partition key to the same group,
+	// as long as the weights don't change.
+	Sticky bool
+}
+
+// ShadowConfig configures mirroring a share of the actions to a candidate
+// group of processors. Mirrored actions never affect what clients receive.
+type ShadowConfig struct {
+	// Group is the name of the group actions are mirrored to
+	Group string
+
+	// Percent of the actions which are mirrored, from 0 to 100
+	Percent float64
+
+	// Diff logs how responses of the shadow differ from the primary's,
+	// instead of discarding them.
+	Diff bool
+}
+
+// FanOutConfig configures sending every action to several groups at once.
+type FanOutConfig struct {
+	// Groups are the names of the groups actions are fanned out to. The
+	// first one is the primary group under the fire_and_forget policy.
+	Groups []string
+
+	// Policy is the name of how responses are aggregated,
+	// see LookupFanOutPolicy.
+	Policy string
+
+	// Quorum is how many groups have to succeed under the quorum policy,
+	// a majority of them by default.
+	Quorum int
+}
+
+// StreamConfig configures the streams opened to each processor endpoint.
+type StreamConfig struct {
+	// Streams is the number of streams opened to each endpoint
+	Streams int
+
+	ConnPerStream bool
+
+	// Ordered delivers actions sharing a partition key in the order they were
+	// sent, see WithOrderedDelivery. Retries would be sent after actions which
+	// came later, so routes with retries can't have ordered streams.
+	Ordered bool
+
+	ResendInFlight    bool
+	SendQueueSize     int
+	FailWhenQueueFull bool
+
+	// Durable logs actions to disk until the processor responds, so they're
+	// delivered at least once, see WithActionLog.
+	Durable bool
+
+	// EjectAfter consecutive failures an endpoint is ejected for EjectFor
+	EjectAfter int
+	EjectFor   time.Duration
+}
+
+// ConfigError lists every problem found when validating a routing config.
+type ConfigError struct {
+	Problems []string
+}
+
+func (e *ConfigError) Error() string {
+	return "invalid routing config: " + strings.Join(e.Problems, "; ")
+}
+
+func (e *ConfigError) addf(format string, args ...interface{}) {
+	e.Problems = append(e.Problems, fmt.Sprintf(format, args...))
+}
+
+// LoadRoutingConfig reads the routing config from cfg and validates it.
+func LoadRoutingConfig(cfg *viper.Viper) (*RoutingConfig, error) {
+	rc := &RoutingConfig{
+		Processors: cfg.GetStringSlice(ProcessorsKey),
+	}
+	err := cfg.UnmarshalKey(RoutesKey, &rc.Routes)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ValidateRoutingConfig(rc)
+	if err != nil {
+		return nil, err
+	}
+	return rc, nil
+}
+
+// ValidateRoutingConfig checks that every route is for a valid action type
+// name, can be balanced and doesn't map the same processor more than once.
+func ValidateRoutingConfig(rc *RoutingConfig) error {
+	cfgs := rc.Routes
+
+	// neither routes nor processors are required, since processors can
+	// also connect to the Gateway themselves
+	cfgErr := new(ConfigError)
+	seenProcessors := make(map[string]bool, len(rc.Processors))
+	for _, addr := range rc.Processors {
+		switch {
+		case addr == "":
+			cfgErr.addf("empty processor address")
+		case seenProcessors[addr]:
+			cfgErr.addf("processor %s is listed more than once", addr)
+		}
+		seenProcessors[addr] = true
+	}
+
+	// endpoints shared between routes must agree on how they're streamed to
+	streamCfgs := make(map[string]StreamConfig)
+	streamRoutes := make(map[string]string)
+
+	validateGroup := func(name string, cfg GroupConfig) {
+		if _, err := LookupBalancer(cfg.Balancer); err != nil {
+			cfgErr.addf("%s: %s", name, err)
+		}
+
+		seen := make(map[string]bool, len(cfg.Endpoints))
+		for _, addr := range cfg.Endpoints {
+			switch {
+			case addr == "":
+				cfgErr.addf("%s: empty endpoint address", name)
+				continue
+			case seen[addr]:
+				cfgErr.addf("%s: endpoint %s is mapped more than once", name, addr)
+				continue
+			}
+			seen[addr] = true
+
+			other, ok := streamCfgs[addr]
+			if !ok {
+				streamCfgs[addr] = cfg.Stream
+				streamRoutes[addr] = name
+				continue
+			}
+			if other != cfg.Stream {
+				cfgErr.addf("%s: endpoint %s is configured differently by %s", name, addr, streamRoutes[addr])
+			}
+		}
+	}
+
+	for _, name := range sortedRouteNames(cfgs) {
+		cfg := cfgs[name]
+
+		if !validTypeName(name) {
+			cfgErr.addf("route %s: invalid action type name", name)
+		}
+
+		validateGroup("route "+name, cfg.GroupConfig)
+		for _, group := range sortedGroupNames(cfg.Groups) {
+			groupCfg := cfg.Groups[group]
+			if group == DefaultGroup {
+				cfgErr.addf("route %s: group %s is configured by the route itself", name, group)
+				continue
+			}
+			if len(groupCfg.Endpoints) == 0 {
+				cfgErr.addf("route %s group %s: no endpoints configured", name, group)
+			}
+			validateGroup("route "+name+" group "+group, groupCfg)
+		}
+
+		validatePipeline(cfgErr, name, cfg, cfgs)
+		validateRules(cfgErr, name, cfg)
+		validateFanOut(cfgErr, name, cfg)
+		validateShadow(cfgErr, name, cfg)
+		validateRetry(cfgErr, name, cfg.Retry)
+
+		ordered := cfg.Stream.Ordered
+		for _, groupCfg := range cfg.Groups {
+			ordered = ordered || groupCfg.Stream.Ordered
+		}
+		if ordered && cfg.Retry.MaxAttempts > 1 {
+			cfgErr.addf("route %s: retries can't be combined with ordered streams", name)
+		}
+
+		for _, err := range validateWeights(cfg.Split.Weights, cfg.Groups) {
+			cfgErr.addf("route %s: %s", name, err)
+		}
+		if len(cfg.Split.Weights) > 0 && len(cfg.FanOut.Groups) > 0 {
+			cfgErr.addf("route %s: split and fan out can't be combined", name)
+		}
+	}
+
+	if len(cfgErr.Problems) > 0 {
+		return cfgErr
+	}
+	return nil
+}
+
+// CheckStorage checks that the storage routes rely on to survive restarts is
+// configured explicitly: an action log directory for durable streams, see
+// WithActionLogDir, and a saga store for pipelines with compensating steps,
+// see WithSagaStore.
+func CheckStorage(rc *RoutingConfig, hasActionLogDir, hasSagaStore bool) error {
+	cfgErr := new(ConfigError)
+	for _, name := range sortedRouteNames(rc.Routes) {
+		cfg := rc.Routes[name]
+
+		durable := cfg.Stream.Durable
+		for _, groupCfg := range cfg.Groups {
+			durable = durable || groupCfg.Stream.Durable
+		}
+		if durable && !hasActionLogDir {
+			cfgErr.addf("route %s: durable streams require an action log directory", name)
+		}
+
+		for i, step := range cfg.Pipeline {
+			if step.Compensate != "" && !hasSagaStore {
+				cfgErr.addf("route %s: pipeline step %d: compensating steps require a saga store", name, i)
+			}
+		}
+	}
+
+	if len(cfgErr.Problems) > 0 {
+		return cfgErr
+	}
+	return nil
+}
+
+// CheckProcessors checks that every processor configured can be connected to
+// before ctx is done and, unless they predate describing themselves, that
+// they declare that they handle the action types routed to them. The info of
+// every processor which described itself is returned by address.
+func CheckProcessors(ctx context.Context, rc *RoutingConfig, dial func(addr string) (*grpc.ClientConn, error)) (map[string]*ProcessorInfo, error) {
+	cfgs := rc.Routes
+
+	addrs := make(map[string]bool)
+	for _, addr := range rc.Processors {
+		addrs[addr] = true
+	}
+	for _, cfg := range cfgs {
+		for _, addr := range cfg.endpoints() {
+			addrs[addr] = true
+		}
+	}
+
+	type result struct {
+		addr string
+		info *ProcessorInfo
+		err  error
+	}
+	results := make(chan result, len(addrs))
+	for addr := range addrs {
+		go func(addr string) {
+			info, err := checkProcessor(ctx, addr, dial)
+			results <- result{addr: addr, info: info, err: err}
+		}(addr)
+	}
+
+	cfgErr := new(ConfigError)
+	infos := make(map[string]*ProcessorInfo, len(addrs))
+	for range addrs {
+		r := <-results
+		if r.err != nil {
+			cfgErr.addf("processor %s is unreachable: %s", r.addr, r.err)
+			continue
+		}
+		if r.info != nil {
+			infos[r.addr] = r.info
+		}
+	}
+
+	for _, name := range sortedRouteNames(cfgs) {
+		for _, addr := range cfgs[name].endpoints() {
+			info, ok := infos[addr]
+			if ok && !declaresType(info, name) {
+				cfgErr.addf("route %s: processor %s doesn't declare that it handles the action type", name, addr)
+			}
+		}
+	}
+
+	if len(cfgErr.Problems) > 0 {
+		sort.Strings(cfgErr.Problems)
+		return infos, cfgErr
+	}
+	return infos, nil
+}
+
+func checkProcessor(ctx context.Context, addr string, dial func(addr string) (*grpc.ClientConn, error)) (*ProcessorInfo, error) {
+	cc, err := dial(addr)
+	if err != nil {
+		return nil, err
+	}
+	defer cc.Close()
+
+	cc.Connect()
+	for state := cc.GetState(); state != connectivity.Ready; state = cc.GetState() {
+		if !cc.WaitForStateChange(ctx, state) {
+			return nil, fmt.Errorf("last connection state %s", state)
+		}
+	}
+
+	info, err := NewProcessorClient(cc).Describe(ctx, new(emptypb.Empty))
+	if status.Code(err) == codes.Unimplemented {
+		return nil, nil
+	}
+	return info, err
+}
+
+func validatePipeline(cfgErr *ConfigError, name string, cfg RouteConfig, cfgs map[string]RouteConfig) {
+	if len(cfg.Pipeline) == 0 {
+		return
+	}
+	if len(cfg.Rules) > 0 || len(cfg.FanOut.Groups) > 0 || len(cfg.Split.Weights) > 0 {
+		cfgErr.addf("route %s: pipeline can't be combined with rules, fan out or split", name)
+	}
+
+	for i, step := range cfg.Pipeline {
+		stepCfg, ok := lookupRouteConfig(cfgs, step.Type)
+		switch {
+		case !ok:
+			cfgErr.addf("route %s: pipeline step %d: no route for action type %s", name, i, step.Type)
+		case len(stepCfg.Pipeline) > 0:
+			cfgErr.addf("route %s: pipeline step %d: action type %s is a pipeline itself", name, i, step.Type)
+		}
+
+		if step.Timeout < 0 {
+			cfgErr.addf("route %s: pipeline step %d: timeout must not be negative", name, i)
+		}
+		if step.OnError != "" && step.OnError != "fail" && step.OnError != "skip" {
+			cfgErr.addf("route %s: pipeline step %d: unknown onError %s", name, i, step.OnError)
+		}
+
+		if step.Compensate == "" {
+			continue
+		}
+		compCfg, ok := lookupRouteConfig(cfgs, step.Compensate)
+		switch {
+		case !ok:
+			cfgErr.addf("route %s: pipeline step %d: no route for compensating action type %s", name, i, step.Compensate)
+		case len(compCfg.Pipeline) > 0:
+			cfgErr.addf("route %s: pipeline step %d: compensating action type %s is a pipeline", name, i, step.Compensate)
+		}
+	}
+}
+
+// lookupRouteConfig returns the config of the route for the action type.
+func lookupRouteConfig(cfgs map[string]RouteConfig, typeName string) (RouteConfig, bool) {
+	for name, cfg := range cfgs {
+		if strings.EqualFold(name, typeName) {
+			return cfg, true
+		}
+	}
+	return RouteConfig{}, false
+}
+
+func validateRules(cfgErr *ConfigError, name string, cfg RouteConfig) {
+	for i, rule := range cfg.Rules {
+		if _, err := compileRule(rule.When); err != nil {
+			cfgErr.addf("route %s: rule %d: %s", name, i, err)
+		}
+
+		group := strings.ToLower(rule.Group)
+		if _, ok := cfg.Groups[group]; !ok && group != DefaultGroup {
+			cfgErr.addf("route %s: rule %d: unknown group %s", name, i, rule.Group)
+		}
+	}
+}
+
+func validateRetry(cfgErr *ConfigError, name string, cfg RetryConfig) {
+	if cfg.MaxAttempts < 0 {
+		cfgErr.addf("route %s: retry max attempts must not be negative", name)
+	}
+	if cfg.BaseDelay < 0 || cfg.MaxDelay < 0 || cfg.AttemptTimeout < 0 {
+		cfgErr.addf("route %s: retry delays and attempt timeout must not be negative", name)
+	}
+	if cfg.Multiplier != 0 && cfg.Multiplier < 1 {
+		cfgErr.addf("route %s: retry multiplier must be at least 1", name)
+	}
+	if cfg.Jitter < 0 || cfg.Jitter > 1 {
+		cfgErr.addf("route %s: retry jitter must be between 0 and 1", name)
+	}
+	for _, code := range cfg.RetryOn {
+		if _, ok := lookupCode(code); !ok {
+			cfgErr.addf("route %s: retry on unknown status code %s", name, code)
+		}
+	}
+}
+
+func validateFanOut(cfgErr *ConfigError, name string, cfg RouteConfig) {
+	fanOut := cfg.FanOut
+
+	policy, err := LookupFanOutPolicy(fanOut.Policy)
+	if err != nil {
+		cfgErr.addf("route %s: %s", name, err)
+	}
+
+	// group names are case insensitive since viper lower cases the keys of Groups
+	seen := make(map[string]bool, len(fanOut.Groups))
+	for _, group := range fanOut.Groups {
+		group = strings.ToLower(group)
+		if _, ok := cfg.Groups[group]; !ok && group != DefaultGroup {
+			cfgErr.addf("route %s: fan out to unknown group %s", name, group)
+		}
+		if seen[group] {
+			cfgErr.addf("route %s: fan out to group %s more than once", name, group)
+		}
+		seen[group] = true
+	}
+
+	if fanOut.Quorum < 0 || fanOut.Quorum > len(fanOut.Groups) {
+		cfgErr.addf("route %s: fan out quorum must be between 0 and the number of groups", name)
+	}
+	if fanOut.Quorum > 0 && policy != Quorum {
+		cfgErr.addf("route %s: fan out quorum is only used by the quorum policy", name)
+	}
+}
+
+func validateShadow(cfgErr *ConfigError, name string, cfg RouteConfig) {
+	sh := cfg.Shadow
+	if sh.Percent < 0 || sh.Percent > 100 {
+		cfgErr.addf("route %s: shadow percent must be between 0 and 100", name)
+	}
+	if sh.Group == "" {
+		if sh.Percent > 0 {
+			cfgErr.addf("route %s: no shadow group configured", name)
+		}
+		return
+	}
+
+	group := strings.ToLower(sh.Group)
+	if _, ok := cfg.Groups[group]; !ok {
+		cfgErr.addf("route %s: shadow to unknown group %s", name, sh.Group)
+	}
+	for _, fanOut := range cfg.FanOut.Groups {
+		if strings.EqualFold(fanOut, group) {
+			cfgErr.addf("route %s: shadow group %s is also fanned out to", name, sh.Group)
+		}
+	}
+}
+
+// validateWeights checks that the weights are for known groups, and that
+// there's at least some weight to split actions by.
+func validateWeights(weights map[string]int, groups map[string]GroupConfig) []error {
+	var errs []error
+	total := 0
+	for _, group := range sortedWeightNames(weights) {
+		w := weights[group]
+		if _, ok := groups[strings.ToLower(group)]; !ok && !strings.EqualFold(group, DefaultGroup) {
+			errs = append(errs, fmt.Errorf("split to unknown group %s", group))
+		}
+		if w < 0 {
+			errs = append(errs, fmt.Errorf("split weight of group %s must not be negative", group))
+		}
+		total += w
+	}
+	if len(weights) > 0 && total <= 0 {
+		errs = append(errs, errors.New("split weights must add up to more than 0"))
+	}
+	return errs
+}
+
+func sortedWeightNames(weights map[string]int) []string {
+	names := make([]string, 0, len(weights))
+	for name := range weights {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// endpoints returns the addresses of every endpoint of the route's groups.
+func (cfg RouteConfig) endpoints() []string {
+	addrs := append([]string(nil), cfg.Endpoints...)
+	for _, group := range cfg.Groups {
+		addrs = append(addrs, group.Endpoints...)
+	}
+	return addrs
+}
+
+func declaresType(info *ProcessorInfo, name string) bool {
+	for _, typ := range info.GetActionTypes() {
+		if strings.EqualFold(typ, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedRouteNames(cfgs map[string]RouteConfig) []string {
+	names := make([]string, 0, len(cfgs))
+	for name := range cfgs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func sortedGroupNames(cfgs map[string]GroupConfig) []string {
+	names := make([]string, 0, len(cfgs))
+	for name := range cfgs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,152 @@
+package action
+
+import (
+	"context"
+	"crypto/subtle"
+	"strings"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// WithConnectAuth lets processors connect to the Gateway, see Connect, when
+// they send the token as "authorization: Bearer <token>" metadata. Unless
+// actionTypes is empty, they may only register with those action types.
+// Processors can't connect unless configured.
+func WithConnectAuth(token string, actionTypes ...string) GatewayOption {
+	return func(g *Gateway) {
+		g.connectToken = token
+		g.connectTypes = make(map[string]bool, len(actionTypes))
+		for _, typ := range actionTypes {
+			g.connectTypes[strings.ToLower(typ)] = true
+		}
+	}
+}
+
+// Connect serves processors which open their stream to the Gateway, instead
+// of being dialed. The action types they register with are routed to them
+// like any other registration, until their stream terminates. Processors
+// have to authenticate, see WithConnectAuth.
+func (s *Gateway) Connect(stream ProcessorGateway_ConnectServer) error {
+	addr := ""
+	if p, ok := peer.FromContext(stream.Context()); ok {
+		addr = p.Addr.String()
+	}
+
+	err := s.authenticate(stream.Context())
+	if err != nil {
+		zap.L().Warn("refused processor connection", zap.String("addr", addr), zap.Error(err))
+		return err
+	}
+
+	resp, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+
+	info := resp.GetRegistration()
+	if info == nil {
+		zap.L().Error("processor didn't register at the start of its stream")
+		return status.Error(codes.InvalidArgument, "first message must be a registration")
+	}
+
+	err = s.authorize(info)
+	if err != nil {
+		zap.L().Warn("refused processor registration", zap.String("addr", addr), zap.String("name", info.GetName()), zap.Error(err))
+		return err
+	}
+
+	if addr == "" {
+		addr = info.GetName()
+	}
+
+	onRegister, setEndpoint := s.registrationHandler()
+	m := NewStreamMux(s.ctx, stream, info, onRegister)
+	setEndpoint(NewEndpoint(addr, m))
+
+	if !s.connect(m) {
+		m.Close(stream.Context())
+		return status.Error(codes.Unavailable, "gateway is shutting down")
+	}
+	defer s.disconnectMux(m)
+
+	zap.L().Info("processor connected", zap.String("addr", addr), zap.String("name", info.GetName()))
+	<-m.Done()
+	zap.L().Info("processor disconnected", zap.String("addr", addr), zap.String("name", info.GetName()))
+
+	if stream.Context().Err() == nil {
+		return status.Error(codes.Unavailable, "gateway closed the stream")
+	}
+	return nil
+}
+
+// connect tracks the Mux of a connected processor so it's drained on Close,
+// returning false if the Gateway is already closed.
+func (s *Gateway) connect(m *Mux) bool {
+	s.connectedMu.Lock()
+	defer s.connectedMu.Unlock()
+
+	if s.connected == nil {
+		return false
+	}
+	s.connected[m] = true
+	return true
+}
+
+func (s *Gateway) disconnectMux(m *Mux) {
+	s.connectedMu.Lock()
+	defer s.connectedMu.Unlock()
+
+	delete(s.connected, m)
+}
+
+// disconnect stops accepting connecting processors and returns the Muxes of
+// the connected ones.
+func (s *Gateway) disconnect() []*Mux {
+	s.connectedMu.Lock()
+	defer s.connectedMu.Unlock()
+
+	muxes := make([]*Mux, 0, len(s.connected))
+	for m := range s.connected {
+		muxes = append(muxes, m)
+	}
+	s.connected = nil
+	return muxes
+}
+
+// authenticate checks that a connecting processor sent the token configured
+// by WithConnectAuth.
+func (s *Gateway) authenticate(ctx context.Context) error {
+	if s.connectToken == "" {
+		return status.Error(codes.PermissionDenied, "processors can't connect to this gateway")
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	auth := md.Get("authorization")
+	if len(auth) == 0 {
+		return status.Error(codes.Unauthenticated, "missing authorization")
+	}
+
+	token := strings.TrimPrefix(auth[0], "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(s.connectToken)) != 1 {
+		return status.Error(codes.Unauthenticated, "invalid authorization")
+	}
+	return nil
+}
+
+// authorize checks that a connecting processor only registers with the action
+// types configured by WithConnectAuth, if any.
+func (s *Gateway) authorize(info *ProcessorInfo) error {
+	if len(s.connectTypes) == 0 {
+		return nil
+	}
+	for _, typ := range info.GetActionTypes() {
+		if !s.connectTypes[strings.ToLower(typ)] {
+			return status.Errorf(codes.PermissionDenied, "processors can't connect for action type %s", typ)
+		}
+	}
+	return nil
+}
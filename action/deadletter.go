@@ -0,0 +1,386 @@
+package action
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrDeadLetterNotFound is returned by a DeadLetterStore for unknown dead letters.
+var ErrDeadLetterNotFound = errors.New("dead letter not found")
+
+// errNoDeadLetterStore is returned by the dead letter operations of a Gateway
+// without a DeadLetterStore.
+var errNoDeadLetterStore = status.Error(codes.FailedPrecondition, "dead letters aren't stored")
+
+// DeadLetter is an action which failed to be processed, e.g. because its
+// processor rejected it, timed out, was unavailable or responded with garbage.
+type DeadLetter struct {
+	ID           string `json:"id"`
+	TypeName     string `json:"type"`
+	PartitionKey string `json:"partitionKey,omitempty"`
+
+	// Payload is set when the payload is JSON, and RawPayload otherwise
+	Payload    json.RawMessage `json:"payload,omitempty"`
+	RawPayload []byte          `json:"rawPayload,omitempty"`
+
+	// Reason is why the action last failed, and Code its gRPC status code
+	Reason string `json:"reason"`
+	Code   string `json:"code"`
+
+	// Processor is the address of the processor the action last failed at,
+	// unless it never reached one
+	Processor string `json:"processor,omitempty"`
+	Attempts  int    `json:"attempts"`
+
+	FirstFailedAt time.Time `json:"firstFailedAt"`
+	LastFailedAt  time.Time `json:"lastFailedAt"`
+}
+
+// action returns the action which failed.
+func (dl *DeadLetter) action() *Action {
+	act := &Action{
+		TypeName:     dl.TypeName,
+		PartitionKey: dl.PartitionKey,
+		Payload:      dl.RawPayload,
+	}
+	if dl.Payload != nil {
+		act.Payload = dl.Payload
+	}
+	if legacy, ok := Action_Type_value[dl.TypeName]; ok {
+		act.Type = Action_Type(legacy)
+	}
+	return act
+}
+
+// failed records another failure of the dead letter's action.
+func (dl *DeadLetter) failed(err error) {
+	st := status.Convert(statusError(err))
+	dl.Reason = st.Message()
+	dl.Code = st.Code().String()
+	dl.Attempts++
+
+	var de *deliveryError
+	if errors.As(err, &de) {
+		dl.Processor = de.addr
+		dl.Attempts += de.attempts - 1
+	}
+
+	dl.LastFailedAt = time.Now()
+	if dl.FirstFailedAt.IsZero() {
+		dl.FirstFailedAt = dl.LastFailedAt
+	}
+}
+
+// DeadLetterStore stores the actions which failed to be processed.
+type DeadLetterStore interface {
+	// Put creates or replaces a dead letter.
+	Put(ctx context.Context, dl *DeadLetter) error
+
+	// Get returns a dead letter, or ErrDeadLetterNotFound.
+	Get(ctx context.Context, id string) (*DeadLetter, error)
+
+	// List returns every dead letter, oldest first.
+	List(ctx context.Context) ([]*DeadLetter, error)
+
+	// Delete removes a dead letter, or returns ErrDeadLetterNotFound.
+	Delete(ctx context.Context, id string) error
+}
+
+// FileDeadLetterStore stores every dead letter as a JSON file in a directory.
+type FileDeadLetterStore struct {
+	dir string
+}
+
+// NewFileDeadLetterStore returns a FileDeadLetterStore storing dead letters
+// in dir, creating it if needed.
+func NewFileDeadLetterStore(dir string) (*FileDeadLetterStore, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+	return &FileDeadLetterStore{dir: dir}, nil
+}
+
+// Put writes the dead letter to a temporary file first and renames it, so a
+// crash never leaves a partially written one behind.
+func (s *FileDeadLetterStore) Put(_ context.Context, dl *DeadLetter) error {
+	b, err := json.Marshal(dl)
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(s.dir, dl.ID+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.Write(b)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), s.path(dl.ID))
+}
+
+func (s *FileDeadLetterStore) Get(_ context.Context, id string) (*DeadLetter, error) {
+	return s.read(s.path(id))
+}
+
+func (s *FileDeadLetterStore) List(_ context.Context) ([]*DeadLetter, error) {
+	paths, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	dls := make([]*DeadLetter, 0, len(paths))
+	for _, path := range paths {
+		dl, err := s.read(path)
+		if errors.Is(err, ErrDeadLetterNotFound) {
+			// deleted in the meantime
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		dls = append(dls, dl)
+	}
+
+	sort.Slice(dls, func(i, j int) bool {
+		return dls[i].FirstFailedAt.Before(dls[j].FirstFailedAt)
+	})
+	return dls, nil
+}
+
+func (s *FileDeadLetterStore) Delete(_ context.Context, id string) error {
+	err := os.Remove(s.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrDeadLetterNotFound
+	}
+	return err
+}
+
+func (s *FileDeadLetterStore) read(path string) (*DeadLetter, error) {
+	b, err := ioutil.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrDeadLetterNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	dl := new(DeadLetter)
+	err = json.Unmarshal(b, dl)
+	if err != nil {
+		return nil, err
+	}
+	return dl, nil
+}
+
+func (s *FileDeadLetterStore) path(id string) string {
+	return filepath.Join(s.dir, filepath.Base(id)+".json")
+}
+
+// deadLetter records the action as a dead letter, unless it failed because
+// the client gave up on it, or it's left in an ActionLog to be redelivered
+// under its original idempotency key, which replaying it wouldn't reuse.
+func (s *Gateway) deadLetter(act *Action, err error) {
+	if s.deadLetters == nil {
+		return
+	}
+	if errors.Is(err, ErrCanceled) || errors.Is(err, context.Canceled) {
+		return
+	}
+	var re *redeliveryError
+	if errors.As(err, &re) {
+		zap.L().Debug("not recording dead letter of action left to be redelivered", zap.String("type", act.GetTypeName()))
+		return
+	}
+
+	uid, uerr := uuid.NewRandom()
+	if uerr != nil {
+		zap.L().Error("failed to record dead letter", zap.Error(uerr))
+		return
+	}
+
+	dl := &DeadLetter{
+		ID:           uid.String(),
+		TypeName:     act.GetTypeName(),
+		PartitionKey: act.GetPartitionKey(),
+	}
+	if json.Valid(act.GetPayload()) {
+		dl.Payload = act.GetPayload()
+	} else {
+		dl.RawPayload = act.GetPayload()
+	}
+	dl.failed(err)
+
+	// the store is written to even if the client is gone
+	perr := s.deadLetters.Put(s.ctx, dl)
+	if perr != nil {
+		zap.L().Error("failed to record dead letter", zap.String("type", dl.TypeName), zap.Error(perr))
+		return
+	}
+	zap.L().Info("recorded dead letter", zap.String("id", dl.ID), zap.String("type", dl.TypeName), zap.String("processor", dl.Processor))
+}
+
+// DeadLetters returns the dead letters of the given action type, or every
+// dead letter if typeName is empty, oldest first.
+func (s *Gateway) DeadLetters(ctx context.Context, typeName string) ([]*DeadLetter, error) {
+	if s.deadLetters == nil {
+		return nil, errNoDeadLetterStore
+	}
+	dls, err := s.deadLetters.List(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if typeName == "" {
+		return dls, nil
+	}
+
+	filtered := dls[:0]
+	for _, dl := range dls {
+		if strings.EqualFold(dl.TypeName, typeName) {
+			filtered = append(filtered, dl)
+		}
+	}
+	return filtered, nil
+}
+
+// DeadLetter returns the dead letter with the given id.
+func (s *Gateway) DeadLetter(ctx context.Context, id string) (*DeadLetter, error) {
+	if s.deadLetters == nil {
+		return nil, errNoDeadLetterStore
+	}
+	dl, err := s.deadLetters.Get(ctx, id)
+	if errors.Is(err, ErrDeadLetterNotFound) {
+		return nil, status.Errorf(codes.NotFound, "no dead letter %s", id)
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return dl, nil
+}
+
+// PurgeDeadLetter deletes the dead letter with the given id.
+func (s *Gateway) PurgeDeadLetter(ctx context.Context, id string) error {
+	if s.deadLetters == nil {
+		return errNoDeadLetterStore
+	}
+	err := s.deadLetters.Delete(ctx, id)
+	if errors.Is(err, ErrDeadLetterNotFound) {
+		return status.Errorf(codes.NotFound, "no dead letter %s", id)
+	}
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	return nil
+}
+
+// PurgeDeadLetters deletes the dead letters of the given action type, or
+// every dead letter if typeName is empty, returning how many were deleted.
+func (s *Gateway) PurgeDeadLetters(ctx context.Context, typeName string) (int, error) {
+	dls, err := s.DeadLetters(ctx, typeName)
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, dl := range dls {
+		err = s.deadLetters.Delete(ctx, dl.ID)
+		if errors.Is(err, ErrDeadLetterNotFound) {
+			continue
+		}
+		if err != nil {
+			return purged, status.Error(codes.Internal, err.Error())
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// ReplayDeadLetter sends the action of a dead letter again, either to the
+// processor at addr, or routed as usual if addr is empty. The dead letter is
+// deleted once the action is processed, and records the failure otherwise.
+func (s *Gateway) ReplayDeadLetter(ctx context.Context, id, addr string) (*ActionResponse, error) {
+	dl, err := s.DeadLetter(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp *ActionResponse
+	if addr == "" {
+		resp, err = s.processAction(withoutDeadLetters(ctx), dl.action())
+	} else {
+		var sender Sender
+		sender, err = s.processorAt(addr)
+		if err != nil {
+			return nil, err
+		}
+
+		var content *Action
+		content, err = sender.SendAction(ctx, dl.action())
+		if err == nil {
+			resp = newActionResponse(content, nil)
+		}
+	}
+	if err != nil {
+		dl.failed(err)
+		perr := s.deadLetters.Put(s.ctx, dl)
+		if perr != nil {
+			zap.L().Error("failed to record dead letter", zap.String("id", dl.ID), zap.Error(perr))
+		}
+		return nil, statusError(err)
+	}
+
+	zap.L().Info("replayed dead letter", zap.String("id", dl.ID), zap.String("type", dl.TypeName), zap.String("processor", addr))
+	return resp, s.PurgeDeadLetter(ctx, id)
+}
+
+// processorAt returns the processor at addr to send actions to, bypassing
+// routing. Only processors which are routed to, or connected and registered,
+// are returned, so callers can't make the Gateway dial arbitrary addresses.
+func (s *Gateway) processorAt(addr string) (Sender, error) {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	for key, e := range s.endpoints {
+		if key.addr == addr {
+			return e.Endpoint, nil
+		}
+	}
+	if e, ok := s.registry.endpoint(addr); ok {
+		return e, nil
+	}
+	return nil, status.Errorf(codes.InvalidArgument, "processor %s is neither routed to nor connected", addr)
+}
+
+type noDeadLettersKey struct{}
+
+// withoutDeadLetters keeps actions which fail from being recorded as dead
+// letters again, since replays update their own dead letter.
+func withoutDeadLetters(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noDeadLettersKey{}, true)
+}
+
+func recordsDeadLetters(ctx context.Context) bool {
+	skip, _ := ctx.Value(noDeadLettersKey{}).(bool)
+	return !skip
+}
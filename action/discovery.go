@@ -0,0 +1,99 @@
+package action
+
+import (
+	"strings"
+	"sync"
+)
+
+// processorRegistry tracks the action types processors registered with at
+// the start of their streams, dropping them once the streams terminate.
+type processorRegistry struct {
+	mu sync.RWMutex
+
+	// version is bumped on every change so routes know when to rebuild
+	// their EndpointGroups.
+	version uint64
+	muxes   map[*Mux]registration
+
+	// byType maps lower cased action type names to the endpoints with a
+	// stream registered for them.
+	byType map[string][]*Endpoint
+}
+
+type registration struct {
+	endpoint *Endpoint
+	info     *ProcessorInfo
+}
+
+func newProcessorRegistry() *processorRegistry {
+	return &processorRegistry{
+		muxes:  make(map[*Mux]registration),
+		byType: make(map[string][]*Endpoint),
+	}
+}
+
+// update records the registration of a stream opened by m to e, or removes
+// it when info is nil.
+func (r *processorRegistry) update(m *Mux, e *Endpoint, info *ProcessorInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if info == nil {
+		delete(r.muxes, m)
+	} else {
+		r.muxes[m] = registration{endpoint: e, info: info}
+	}
+
+	byType := make(map[string][]*Endpoint)
+	seen := make(map[string]map[*Endpoint]bool)
+	for _, reg := range r.muxes {
+		for _, typ := range reg.info.GetActionTypes() {
+			typ = strings.ToLower(typ)
+			if seen[typ] == nil {
+				seen[typ] = make(map[*Endpoint]bool)
+			}
+			if seen[typ][reg.endpoint] {
+				continue
+			}
+			seen[typ][reg.endpoint] = true
+			byType[typ] = append(byType[typ], reg.endpoint)
+		}
+	}
+
+	r.byType = byType
+	r.version++
+}
+
+// endpoints returns the endpoints registered for the action type along with
+// the current version of the registry.
+func (r *processorRegistry) endpoints(typeName string) ([]*Endpoint, uint64) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.byType[strings.ToLower(typeName)], r.version
+}
+
+// processors returns the registrations of every open processor stream.
+func (r *processorRegistry) processors() []*ProcessorInfo {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	infos := make([]*ProcessorInfo, 0, len(r.muxes))
+	for _, reg := range r.muxes {
+		infos = append(infos, reg.info)
+	}
+	return infos
+}
+
+// endpoint returns the endpoint of an open processor stream by its address.
+func (r *processorRegistry) endpoint(addr string) (*Endpoint, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, reg := range r.muxes {
+		if reg.endpoint != nil && reg.endpoint.Addr() == addr {
+			return reg.endpoint, true
+		}
+	}
+	return nil, false
+}
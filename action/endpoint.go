@@ -0,0 +1,146 @@
+package action
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Endpoint is a single processor replica which Actions can be sent to.
+// Endpoints are ejected from load balancing after consecutive failures.
+type Endpoint struct {
+	addr   string
+	sender Sender
+
+	maxFailures   int32
+	ejectDuration time.Duration
+
+	failures     int32
+	ejectedUntil int64
+}
+
+type EndpointOption func(*Endpoint)
+
+// WithEjection configures how many consecutive failures eject an Endpoint
+// and for how long it stays ejected. The default is 3 failures and 30s.
+func WithEjection(maxFailures int, d time.Duration) EndpointOption {
+	return func(e *Endpoint) {
+		e.maxFailures = int32(maxFailures)
+		e.ejectDuration = d
+	}
+}
+
+// NewEndpoint returns an Endpoint which sends Actions to the processor at
+// addr through the given Sender.
+func NewEndpoint(addr string, sender Sender, opts ...EndpointOption) *Endpoint {
+	e := &Endpoint{
+		addr:          addr,
+		sender:        sender,
+		maxFailures:   3,
+		ejectDuration: 30 * time.Second,
+	}
+
+	for _, opt := range opts {
+		opt(e)
+	}
+
+	return e
+}
+
+// Addr returns the address of the processor.
+func (e *Endpoint) Addr() string {
+	return e.addr
+}
+
+// InFlight returns the number of actions awaiting a response from the processor.
+func (e *Endpoint) InFlight() int {
+	if s, ok := e.sender.(interface{ InFlight() int }); ok {
+		return s.InFlight()
+	}
+	return 0
+}
+
+// Healthy reports whether the Endpoint is currently not ejected.
+func (e *Endpoint) Healthy() bool {
+	return time.Now().UnixNano() >= atomic.LoadInt64(&e.ejectedUntil)
+}
+
+func (e *Endpoint) SendAction(ctx context.Context, act *Action) (*Action, error) {
+	resp, err := e.sender.SendAction(ctx, act)
+	switch {
+	case err == nil:
+		atomic.StoreInt32(&e.failures, 0)
+		return resp, nil
+	case errors.Is(err, ErrProcessorUnavailable), errors.Is(err, ErrProtocolViolation):
+		e.failed()
+	}
+	return nil, &deliveryError{addr: e.addr, attempts: 1, err: err}
+}
+
+// deliveryError is an action failing to be processed by the processor at
+// addr, after the given number of attempts.
+type deliveryError struct {
+	addr     string
+	attempts int
+	err      error
+}
+
+func (e *deliveryError) Error() string {
+	return e.err.Error()
+}
+
+func (e *deliveryError) Unwrap() error {
+	return e.err
+}
+
+func (e *Endpoint) failed() {
+	if e.maxFailures <= 0 || atomic.AddInt32(&e.failures, 1) < e.maxFailures {
+		return
+	}
+
+	atomic.StoreInt32(&e.failures, 0)
+	atomic.StoreInt64(&e.ejectedUntil, time.Now().Add(e.ejectDuration).UnixNano())
+	zap.L().Warn("ejected processor endpoint", zap.String("addr", e.addr), zap.Duration("duration", e.ejectDuration))
+}
+
+// EndpointGroup load balances Actions across replicas of the same processor.
+// Actions with a partition key are always consistently hashed onto the
+// replicas, regardless of the Balancer, so related Actions stay together.
+type EndpointGroup struct {
+	endpoints []*Endpoint
+	balancer  Balancer
+	ring      Balancer
+}
+
+// NewEndpointGroup returns an EndpointGroup which balances Actions across
+// the given endpoints using a Balancer built by newBalancer.
+func NewEndpointGroup(endpoints []*Endpoint, newBalancer BalancerBuilder) *EndpointGroup {
+	return &EndpointGroup{
+		endpoints: endpoints,
+		balancer:  newBalancer(endpoints),
+		ring:      NewConsistentHashBalancer(endpoints),
+	}
+}
+
+// Endpoints returns the endpoints actions are balanced across.
+func (g *EndpointGroup) Endpoints() []*Endpoint {
+	return g.endpoints
+}
+
+func (g *EndpointGroup) SendAction(ctx context.Context, act *Action) (*Action, error) {
+	b := g.balancer
+	key := act.GetPartitionKey()
+	if key != "" {
+		b = g.ring
+	}
+
+	e := b.Pick(key)
+	if e == nil {
+		return nil, fmt.Errorf("%w: no endpoints available", ErrProcessorUnavailable)
+	}
+	return e.SendAction(ctx, act)
+}
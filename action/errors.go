@@ -0,0 +1,151 @@
+package action
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	// ErrProcessorUnavailable is returned when an action couldn't be delivered
+	// to a processor or its stream terminated before responding.
+	ErrProcessorUnavailable = errors.New("processor unavailable")
+
+	// ErrDeadlineExceeded is returned when the deadline of an action expired
+	// before its processor responded.
+	ErrDeadlineExceeded = errors.New("deadline exceeded before processor responded")
+
+	// ErrCanceled is returned when an action was canceled before its processor
+	// responded.
+	ErrCanceled = errors.New("canceled before processor responded")
+
+	// ErrQueueFull is returned when an action couldn't be queued up to be
+	// sent to a processor because too many actions are already queued.
+	ErrQueueFull = errors.New("processor send queue is full")
+
+	// ErrProtocolViolation is returned when a processor responds with
+	// something the Processor service definition doesn't allow for.
+	ErrProtocolViolation = errors.New("processor violated protocol")
+)
+
+// contextError translates ctx.Err() into its corresponding sentinel error.
+func contextError(ctx context.Context) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return ErrDeadlineExceeded
+	}
+	return ErrCanceled
+}
+
+// statusError converts errors returned by Mux.SendAction into gRPC status errors.
+func statusError(err error) error {
+	if de, ok := err.(*deliveryError); ok {
+		err = de.err
+	}
+
+	switch {
+	case errors.Is(err, ErrProcessorUnavailable):
+		return status.Error(codes.Unavailable, err.Error())
+	case errors.Is(err, ErrQueueFull):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, ErrDeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	case errors.Is(err, ErrCanceled):
+		return status.Error(codes.Canceled, err.Error())
+	case errors.Is(err, ErrProtocolViolation):
+		return status.Error(codes.Internal, err.Error())
+	}
+
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+
+	// keep the code of wrapped status errors, e.g. of pipeline steps
+	var se interface{ GRPCStatus() *status.Status }
+	if errors.As(err, &se) {
+		return status.Error(se.GRPCStatus().Code(), err.Error())
+	}
+	return status.Error(codes.Unknown, err.Error())
+}
+
+// httpStatusCode maps gRPC status codes onto their closest HTTP status code.
+func httpStatusCode(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		// non-standard, but widely used, "Client Closed Request"
+		return 499
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// ProcessorError is returned when a processor reports that it rejected,
+// or failed to process, an action.
+type ProcessorError struct {
+	status *status.Status
+}
+
+func newProcessorError(s *Status) *ProcessorError {
+	return &ProcessorError{
+		status: status.FromProto(&spb.Status{
+			Code:    s.GetCode(),
+			Message: s.GetMessage(),
+			Details: s.GetDetails(),
+		}),
+	}
+}
+
+func (e *ProcessorError) Error() string {
+	return fmt.Sprintf("processor error: code = %s desc = %s", e.status.Code(), e.status.Message())
+}
+
+// GRPCStatus allows ProcessorErrors to be converted by the grpc/status package.
+func (e *ProcessorError) GRPCStatus() *status.Status {
+	return e.status
+}
+
+// NewErrorResponse builds a ProcessorResponse which reports err back to
+// the Gateway. The gRPC status of err is preserved, if it has one.
+func NewErrorResponse(id string, err error) *ProcessorResponse {
+	return &ProcessorResponse{
+		Id: id,
+		Body: &ProcessorResponse_Error{
+			Error: newStatus(err),
+		},
+	}
+}
+
+// newStatus converts err, which should be a gRPC status error, to a Status.
+func newStatus(err error) *Status {
+	st := status.Convert(err)
+
+	return &Status{
+		Code:    int32(st.Code()),
+		Message: st.Message(),
+		Details: st.Proto().GetDetails(),
+	}
+}
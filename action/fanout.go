@@ -0,0 +1,242 @@
+package action
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fireAndForgetTimeout bounds how long actions are sent in the background,
+// e.g. to secondary groups, for when the action itself has no deadline.
+const fireAndForgetTimeout = 30 * time.Second
+
+// FanOutPolicy determines when an action fanned out to several groups of
+// processors succeeds, and what it's responded to with.
+type FanOutPolicy int
+
+const (
+	// AllResponses waits for every group and responds with their content
+	// merged into a JSON object keyed by group name. It only fails when
+	// every group failed.
+	AllResponses FanOutPolicy = iota
+
+	// FirstSuccess responds as soon as any group succeeds, with its
+	// response, and cancels the rest.
+	FirstSuccess
+
+	// Quorum responds as soon as a quorum of groups succeeded, with their
+	// content merged like AllResponses, and cancels the rest.
+	Quorum
+
+	// FireAndForget responds with the response of the primary group, the
+	// first one, and sends the action to the other groups in the background.
+	FireAndForget
+)
+
+var fanOutPolicies = map[string]FanOutPolicy{
+	"all":             AllResponses,
+	"first_success":   FirstSuccess,
+	"quorum":          Quorum,
+	"fire_and_forget": FireAndForget,
+	"":                AllResponses,
+}
+
+// LookupFanOutPolicy returns the FanOutPolicy with the given name.
+// The empty name refers to the default, all.
+func LookupFanOutPolicy(name string) (FanOutPolicy, error) {
+	p, ok := fanOutPolicies[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown fan out policy: %s", name)
+	}
+	return p, nil
+}
+
+// fanOut sends actions to several groups of a route at once.
+type fanOut struct {
+	groups []string
+	policy FanOutPolicy
+	quorum int
+}
+
+func newFanOut(cfg FanOutConfig) *fanOut {
+	// the policy was already validated
+	policy, _ := LookupFanOutPolicy(cfg.Policy)
+
+	f := &fanOut{
+		groups: make([]string, len(cfg.Groups)),
+		policy: policy,
+		quorum: cfg.Quorum,
+	}
+	for i, group := range cfg.Groups {
+		f.groups[i] = strings.ToLower(group)
+	}
+	if f.quorum == 0 {
+		f.quorum = len(f.groups)/2 + 1
+	}
+	return f
+}
+
+type groupResult struct {
+	index int
+	act   *Action
+	err   error
+}
+
+func (f *fanOut) send(ctx context.Context, s *Gateway, r *route, typeName string, act *Action) (*Action, []*PartialFailure, error) {
+	if f.policy == FireAndForget {
+		return f.sendPrimary(ctx, s, r, typeName, act)
+	}
+
+	need := len(f.groups)
+	switch f.policy {
+	case FirstSuccess:
+		need = 1
+	case Quorum:
+		need = f.quorum
+	}
+
+	// the groups still being sent to once enough have succeeded are canceled
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan groupResult, len(f.groups))
+	for i, group := range f.groups {
+		go func(i int, t *target) {
+			resp, err := t.sendAction(ctx, s.registry, typeName, act)
+			results <- groupResult{index: i, act: resp, err: err}
+		}(i, r.groups[group])
+	}
+
+	succeeded := make([]*groupResult, len(f.groups))
+	var successes int
+	var failures []*PartialFailure
+	for range f.groups {
+		res := <-results
+		if res.err != nil {
+			failures = append(failures, &PartialFailure{
+				Group:  f.groups[res.index],
+				Status: newStatus(statusError(res.err)),
+			})
+
+			if f.policy != AllResponses && len(f.groups)-len(failures) < need {
+				break
+			}
+			continue
+		}
+
+		succeeded[res.index] = &res
+		successes++
+		if successes == need {
+			break
+		}
+	}
+	sort.Slice(failures, func(i, j int) bool {
+		return failures[i].GetGroup() < failures[j].GetGroup()
+	})
+
+	if successes == 0 || (f.policy != AllResponses && successes < need) {
+		return nil, nil, fanOutError(failures)
+	}
+
+	if f.policy == FirstSuccess {
+		for _, res := range succeeded {
+			if res != nil {
+				return res.act, failures, nil
+			}
+		}
+	}
+
+	merged, err := f.merge(succeeded)
+	if err != nil {
+		return nil, nil, err
+	}
+	return merged, failures, nil
+}
+
+// sendPrimary sends the action to the primary group, and to the other groups
+// in the background, where their failures are only logged.
+func (f *fanOut) sendPrimary(ctx context.Context, s *Gateway, r *route, typeName string, act *Action) (*Action, []*PartialFailure, error) {
+	for _, group := range f.groups[1:] {
+		go func(group string, t *target) {
+			bgCtx, cancel := backgroundContext(s.ctx, ctx)
+			defer cancel()
+
+			_, err := t.sendAction(bgCtx, s.registry, typeName, act)
+			if err != nil {
+				zap.L().Warn(
+					"failed to send action to secondary group",
+					zap.String("type", typeName),
+					zap.String("group", group),
+					zap.Error(err),
+				)
+			}
+		}(group, r.groups[group])
+	}
+
+	resp, err := r.groups[f.groups[0]].sendAction(ctx, s.registry, typeName, act)
+	return resp, nil, err
+}
+
+// merge merges the content of the groups which succeeded into a JSON object
+// keyed by group name. Groups which processed the action without responding
+// with any content are null.
+func (f *fanOut) merge(succeeded []*groupResult) (*Action, error) {
+	merged := make(map[string]json.RawMessage, len(succeeded))
+	for i, res := range succeeded {
+		if res == nil {
+			continue
+		}
+
+		content := res.act.GetPayload()
+		switch {
+		case res.act == nil:
+			content = json.RawMessage("null")
+		case !json.Valid(content):
+			// processors aren't required to respond with JSON
+			b, err := json.Marshal(string(content))
+			if err != nil {
+				return nil, err
+			}
+			content = b
+		}
+		merged[f.groups[i]] = content
+	}
+
+	b, err := json.Marshal(merged)
+	if err != nil {
+		return nil, err
+	}
+	return &Action{Payload: b}, nil
+}
+
+// backgroundContext returns a context derived from parent, instead of ctx,
+// for sending an action beyond the lifetime of its request. It keeps the
+// deadline of ctx, or is bounded by fireAndForgetTimeout if there's none.
+func backgroundContext(parent, ctx context.Context) (context.Context, context.CancelFunc) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(fireAndForgetTimeout)
+	}
+	return context.WithDeadline(parent, deadline)
+}
+
+// fanOutError is the error of an action which every group, or too many of
+// them, failed to process. It has the code of the first failure.
+func fanOutError(failures []*PartialFailure) error {
+	if len(failures) == 0 {
+		return status.Error(codes.Unavailable, "fan out failed")
+	}
+
+	msgs := make([]string, len(failures))
+	for i, f := range failures {
+		msgs[i] = f.GetGroup() + ": " + f.GetStatus().GetMessage()
+	}
+	return status.Error(codes.Code(failures[0].GetStatus().GetCode()), "fan out failed: "+strings.Join(msgs, "; "))
+}
@@ -2,11 +2,16 @@ package action
 
 import (
 	"bytes"
+	"encoding/json"
 	"io/ioutil"
 	"sync"
 
 	"github.com/valyala/fasthttp"
 	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
 )
 
 var bufPool = &sync.Pool{
@@ -26,30 +31,58 @@ func NewFastHTTPHandler(g *Gateway) fasthttp.RequestHandler {
 		err := ctx.Request.BodyWriteTo(b)
 		if err != nil {
 			zap.L().Error("unexpected error when reading request body")
+			ctx.Error("unexpected error when reading request body", 500)
 			return
 		}
 
-		act, err := decodeActionFromJSON(ioutil.NopCloser(b))
+		act, err := decodeActionFromJSON(ioutil.NopCloser(b), g.Types())
 		if err != nil {
-			zap.L().Error("unexpected error when decoding action from json")
+			zap.L().Error("unexpected error when decoding action from json", zap.Error(err))
+			writeFastHTTPProblem(ctx, newProblem(status.Error(codes.InvalidArgument, err.Error())))
 			return
 		}
 
 		actReq := &ActionRequest{
 			Action: &act,
 		}
-		resp, err := g.ProcessAction(ctx, actReq)
+		md := make(metadata.MD)
+		ctx.Request.Header.VisitAll(func(k, v []byte) {
+			md.Append(string(k), string(v))
+		})
+
+		pctx := peer.NewContext(metadata.NewIncomingContext(ctx, md), &peer.Peer{Addr: ctx.RemoteAddr()})
+		resp, err := g.ProcessAction(pctx, actReq)
 		if err != nil {
 			zap.L().Error("unexpected error when processing event", zap.Error(err))
+			writeFastHTTPProblem(ctx, newProblem(err))
 			return
 		}
 
+		for _, v := range partialFailureValues(resp.GetPartialFailures()) {
+			ctx.Response.Header.Add(partialFailureHeader, v)
+		}
+
 		switch x := resp.GetBody().(type) {
 		case *ActionResponse_Content:
 			ctx.SetStatusCode(200)
 			ctx.Success("application/json", x.Content)
 		case *ActionResponse_WasProcessed:
 			ctx.SetStatusCode(204)
+		case *ActionResponse_Accepted:
+			ctx.SetStatusCode(202)
 		}
 	}
 }
+
+func writeFastHTTPProblem(ctx *fasthttp.RequestCtx, p *problem) {
+	b, err := json.Marshal(p)
+	if err != nil {
+		zap.L().Error("unexpected error when encoding problem details", zap.Error(err))
+		ctx.Error(p.Detail, p.Status)
+		return
+	}
+
+	ctx.SetStatusCode(p.Status)
+	ctx.SetContentType(problemContentType)
+	ctx.SetBody(b)
+}
@@ -2,79 +2,302 @@ package action
 
 import (
 	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/Zaba505/eventproc/orchestration"
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
 )
 
-// Gateway is a service exposed to clients for sending actions to.
+// Gateway is a service exposed to clients for sending actions to. It's also
+// the ProcessorGateway service processors which can't be dialed connect to.
 type Gateway struct {
 	UnimplementedGatewayServer
+	UnimplementedProcessorGatewayServer
 
-	clientMap map[string]*Mux
-	cfg       *viper.Viper
+	cfg          *viper.Viper
+	ctx          context.Context
+	dial         func(addr string) (*grpc.ClientConn, error)
+	drainTimeout time.Duration
+
+	// reloadMu serializes reloads, which are the only writers of endpoints
+	reloadMu  sync.Mutex
+	endpoints map[endpointKey]*managedEndpoint
+
+	// table holds the current *RoutingTable
+	table    atomic.Value
+	types    *TypeRegistry
+	registry *processorRegistry
+
+	// connected holds the Muxes of connected processors, see Connect, which
+	// authenticate with connectToken and may only register connectTypes
+	connectedMu  sync.Mutex
+	connected    map[*Mux]bool
+	connectToken string
+	connectTypes map[string]bool
+
+	// sagas runs pipelines with compensating steps, and records them in
+	// sagaStore, while pipelines runs the others without recording them
+	sagaStore orchestration.Store
+	sagas     *orchestration.Coordinator
+	pipelines *orchestration.Coordinator
+
+	// operations holds actions processed in the background, see Submit
+	operations     *operationStore
+	operationTTL   time.Duration
+	asyncTimeout   time.Duration
+	callbackHosts  map[string]bool
+	callbackClient *http.Client
+
+	// idempotency holds the responses to actions sent with idempotency keys
+	idempotency    *idempotencyStore
+	idempotencyTTL time.Duration
+
+	// deadLetters holds the actions which failed to be processed
+	deadLetters DeadLetterStore
+
+	// actionLogs holds the log of every processor address streamed to durably
+	actionLogDir string
+	actionLogs   map[string]*ActionLog
+}
+
+type GatewayOption func(*Gateway)
+
+// WithDialer configures how connections to processors are dialed.
+// The default dials without transport security.
+func WithDialer(dial func(addr string) (*grpc.ClientConn, error)) GatewayOption {
+	return func(g *Gateway) {
+		g.dial = dial
+	}
+}
+
+// WithDrainTimeout configures how long processors which are no longer routed
+// to have for their in-flight actions to complete. The default is 30s.
+func WithDrainTimeout(d time.Duration) GatewayOption {
+	return func(g *Gateway) {
+		g.drainTimeout = d
+	}
+}
+
+// WithSagaStore configures where the state and outcome of pipelines with
+// compensating steps are recorded. It's required by such pipelines.
+func WithSagaStore(store orchestration.Store) GatewayOption {
+	return func(g *Gateway) {
+		g.sagaStore = store
+	}
+}
+
+// WithOperationTTL configures how long the outcome of an action submitted
+// for processing in the background is kept once done. The default is 1h.
+func WithOperationTTL(d time.Duration) GatewayOption {
+	return func(g *Gateway) {
+		g.operationTTL = d
+	}
+}
+
+// WithAsyncTimeout configures how long actions submitted for processing in
+// the background may take. The default is 10m.
+func WithAsyncTimeout(d time.Duration) GatewayOption {
+	return func(g *Gateway) {
+		g.asyncTimeout = d
+	}
+}
+
+// WithCallbackHosts configures the only hosts the outcome of actions processed
+// in the background may be POSTed to, which may then also be private ones,
+// e.g. internal services. By default, any host is allowed, as long as it
+// doesn't resolve to a loopback, private or link-local address.
+func WithCallbackHosts(hosts ...string) GatewayOption {
+	return func(g *Gateway) {
+		g.callbackHosts = make(map[string]bool, len(hosts))
+		for _, host := range hosts {
+			g.callbackHosts[strings.ToLower(host)] = true
+		}
+	}
+}
+
+// WithIdempotencyTTL configures how long the response to an action sent with
+// an idempotency key is replayed for. The default is 24h.
+func WithIdempotencyTTL(d time.Duration) GatewayOption {
+	return func(g *Gateway) {
+		g.idempotencyTTL = d
+	}
+}
+
+// WithDeadLetterStore configures where actions which failed to be processed
+// are stored. They aren't stored unless configured.
+func WithDeadLetterStore(store DeadLetterStore) GatewayOption {
+	return func(g *Gateway) {
+		g.deadLetters = store
+	}
+}
+
+// WithActionLogDir configures the directory the actions sent to processors
+// with durable streams are logged in, under a directory per processor address.
+// It's required by durable streams, and must survive restarts, so neither a
+// temporary nor a per service private directory will do.
+func WithActionLogDir(dir string) GatewayOption {
+	return func(g *Gateway) {
+		g.actionLogDir = dir
+	}
 }
 
-func NewGateway(cfg *viper.Viper, clientMap map[string]*Mux) *Gateway {
-	return &Gateway{
-		clientMap: clientMap,
-		cfg:       cfg,
+// NewGateway returns a Gateway which routes actions to processors according
+// to the RoutesKey config. Processors stay connected to until ctx is done.
+func NewGateway(ctx context.Context, cfg *viper.Viper, opts ...GatewayOption) (*Gateway, error) {
+	g := &Gateway{
+		cfg: cfg,
+		ctx: ctx,
+		dial: func(addr string) (*grpc.ClientConn, error) {
+			return grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		},
+		drainTimeout:   30 * time.Second,
+		types:          NewTypeRegistry(),
+		registry:       newProcessorRegistry(),
+		connected:      make(map[*Mux]bool),
+		operationTTL:   time.Hour,
+		asyncTimeout:   10 * time.Minute,
+		idempotencyTTL: 24 * time.Hour,
+		actionLogs:     make(map[string]*ActionLog),
+	}
+
+	for _, opt := range opts {
+		opt(g)
 	}
+
+	g.callbackClient = newCallbackClient(len(g.callbackHosts) == 0)
+
+	g.pipelines = orchestration.NewCoordinator(ctx)
+	g.sagas = g.pipelines
+	if g.sagaStore != nil {
+		g.sagas = orchestration.NewCoordinator(ctx, orchestration.WithStore(g.sagaStore))
+	}
+
+	g.operations = newOperationStore(g.operationTTL)
+	g.idempotency = newIdempotencyStore(g.idempotencyTTL)
+
+	err := g.Reload()
+	if err != nil {
+		return nil, err
+	}
+
+	go g.operations.run(ctx)
+	go g.idempotency.run(ctx)
+	return g, nil
+}
+
+// Types returns the registry of action types known to the Gateway.
+func (s *Gateway) Types() *TypeRegistry {
+	return s.types
 }
 
-const TypeToProcessorMapKey = "actionToProcessorKey"
+// Processors returns the registrations of every processor stream which is
+// currently open.
+func (s *Gateway) Processors() []*ProcessorInfo {
+	return s.registry.processors()
+}
 
+// ProcessAction routes the action to its processors and returns their
+// response. Actions sent with an idempotency key, see IdempotencyKeyHeader,
+// are only processed once per client.
 func (s *Gateway) ProcessAction(ctx context.Context, req *ActionRequest) (*ActionResponse, error) {
+	key, _ := idempotencyKey(ctx)
+	return s.processIdempotent(ctx, req, key)
+}
+
+// processIdempotent processes the action of req, replaying the outcome of the
+// first call made with the client scoped idempotency key, unless it's empty.
+func (s *Gateway) processIdempotent(ctx context.Context, req *ActionRequest, key string) (*ActionResponse, error) {
 	act := req.GetAction()
 	if act == nil {
 		zap.L().Error("action must not be nil")
 		return nil, status.Error(codes.InvalidArgument, "action must not be nil")
 	}
 
-	v := s.cfg.Get(TypeToProcessorMapKey)
-	if v == nil {
-		zap.L().Error("no action type mapper config provided")
-		return nil, status.Error(codes.Internal, "no action type mapper config provided")
+	if key == "" {
+		return s.processAction(ctx, act)
 	}
+	return s.idempotency.do(ctx, key, actionFingerprint(act), func() (*ActionResponse, error) {
+		return s.processAction(withIdempotencyKey(ctx, key), act)
+	})
+}
 
-	mapper, ok := v.(map[Action_Type]string)
-	if !ok {
-		zap.L().Error("incorrect type provided for action type mapper config")
-		return nil, status.Error(codes.Internal, "incorrect type provided for action type mapper config")
+func (s *Gateway) processAction(ctx context.Context, act *Action) (*ActionResponse, error) {
+	typeName := TypeName(act)
+	if name, ok := s.types.Lookup(typeName); ok {
+		typeName = name
 	}
+	act.TypeName = typeName
 
-	var clientProcId string
-	for actType, clientId := range mapper {
-		if actType == act.GetType() {
-			clientProcId = clientId
-			break
-		}
+	table := s.routingTable()
+	r, ok := table.lookup(typeName)
+	if !ok && table != nil {
+		r = table.registered
 	}
-	if clientProcId == "" {
-		zap.L().Error("unknown payload type", zap.String("type", act.GetType().String()))
+
+	if !ok && (r == nil || r.endpointGroup(s.registry, typeName) == nil) {
+		zap.L().Error("unknown payload type", zap.String("type", typeName))
 		return nil, status.Error(codes.Unimplemented, "unknown action type")
 	}
 
-	client, ok := s.clientMap[clientProcId]
-	if !ok {
-		zap.L().Error("no processor found", zap.String("processorId", clientProcId))
-		return nil, status.Errorf(codes.Unimplemented, "no processor found")
+	err := r.setPartitionKey(act)
+	if err != nil {
+		zap.L().Error("failed to extract partition key from payload", zap.Error(err))
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	respAction, failures, err := r.send(ctx, s, typeName, act)
+	var re *redeliveryError
+	if r.pipeline == nil && errors.As(err, &re) {
+		zap.L().Warn("action will be redelivered", zap.String("type", typeName), zap.Error(err))
+		return &ActionResponse{Body: &ActionResponse_Accepted{Accepted: new(emptypb.Empty)}}, nil
+	}
+	if err != nil {
+		zap.L().Error("failed to process action", zap.String("type", typeName), zap.Error(err))
+		if recordsDeadLetters(ctx) {
+			s.deadLetter(act, err)
+		}
+		return nil, statusError(err)
+	}
+	for _, f := range failures {
+		zap.L().Warn(
+			"action partially failed",
+			zap.String("type", typeName),
+			zap.String("group", f.GetGroup()),
+			zap.String("error", f.GetStatus().GetMessage()),
+		)
 	}
 
-	respAction := client.SendAction(ctx, act)
+	return newActionResponse(respAction, failures), nil
+}
+
+// newActionResponse responds with the content of the action, or that it was
+// processed if there's no action.
+func newActionResponse(respAction *Action, failures []*PartialFailure) *ActionResponse {
 	if respAction == nil {
 		zap.L().Debug("received nil response action")
-		// TODO: come up with better strategy for this
-		return new(ActionResponse), nil
+		return &ActionResponse{
+			Body: &ActionResponse_WasProcessed{
+				WasProcessed: new(emptypb.Empty),
+			},
+			PartialFailures: failures,
+		}
 	}
 
-	resp := &ActionResponse{
+	return &ActionResponse{
 		Body: &ActionResponse_Content{
 			Content: respAction.GetPayload(),
 		},
+		PartialFailures: failures,
 	}
-
-	return resp, nil
 }
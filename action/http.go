@@ -1,35 +1,54 @@
 package action
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"strings"
 
+	"github.com/gorilla/mux"
 	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
 )
 
 // NewHTTPHandler wraps a Gateway service to expose it over an HTTP based API.
 func NewHTTPHandler(s *Gateway) http.HandlerFunc {
 	return func(w http.ResponseWriter, req *http.Request) {
-		act, err := decodeActionFromJSON(req.Body)
+		act, err := decodeActionFromJSON(req.Body, s.Types())
 		if err != nil {
 			zap.L().Error("unexpected error when decoding request body", zap.Error(err))
-			http.Error(w, "unexpected error when decoding request body", 500)
+			writeProblem(w, newProblem(status.Error(codes.InvalidArgument, err.Error())))
 			return
 		}
 
 		actReq := &ActionRequest{
 			Action: &act,
 		}
-		resp, err := s.ProcessAction(req.Context(), actReq)
+		ctx := requestContext(req)
+		if prefersAsync(req.Header) {
+			submit(ctx, w, req, s, actReq)
+			return
+		}
+
+		resp, err := s.ProcessAction(ctx, actReq)
 		if err != nil {
 			zap.L().Error("unexpected error when processing event", zap.Error(err))
-			http.Error(w, "unexpected error when processing event", 500)
+			writeProblem(w, newProblem(err))
 			return
 		}
 
+		for _, v := range partialFailureValues(resp.GetPartialFailures()) {
+			w.Header().Add(partialFailureHeader, v)
+		}
+
 		switch x := resp.GetBody().(type) {
 		case *ActionResponse_Content:
 			w.Header().Set("Content-Type", "application/json")
@@ -42,11 +61,156 @@ func NewHTTPHandler(s *Gateway) http.HandlerFunc {
 			}
 		case *ActionResponse_WasProcessed:
 			w.WriteHeader(204)
+		case *ActionResponse_Accepted:
+			w.WriteHeader(202)
+		}
+	}
+}
+
+// NewOperationsHandler exposes actions processed in the background over HTTP:
+//
+//	POST /operations       submit an action, like POST /action with Prefer: respond-async
+//	GET  /operations/{id}  the status and, once done, the outcome of a submitted action
+func NewOperationsHandler(s *Gateway) http.Handler {
+	r := mux.NewRouter()
+
+	r.Methods(http.MethodPost).Path("/operations").HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		act, err := decodeActionFromJSON(req.Body, s.Types())
+		if err != nil {
+			zap.L().Error("unexpected error when decoding request body", zap.Error(err))
+			writeProblem(w, newProblem(status.Error(codes.InvalidArgument, err.Error())))
+			return
+		}
+
+		ctx := requestContext(req)
+		submit(ctx, w, req, s, &ActionRequest{Action: &act})
+	})
+
+	r.Methods(http.MethodGet).Path("/operations/{id}").HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		op, err := s.Operation(mux.Vars(req)["id"])
+		if err != nil {
+			writeProblem(w, newProblem(err))
+			return
 		}
+		writeJSON(w, http.StatusOK, op)
+	})
+
+	return r
+}
+
+// callbackHeader is the URL the outcome of an action submitted for processing
+// in the background is POSTed to, once done.
+const callbackHeader = "Callback-Url"
+
+// submit processes the action in the background, responding with where its
+// outcome can be polled for.
+func submit(ctx context.Context, w http.ResponseWriter, req *http.Request, s *Gateway, actReq *ActionRequest) {
+	op, err := s.Submit(ctx, actReq, req.Header.Get(callbackHeader))
+	if err != nil {
+		zap.L().Error("unexpected error when submitting action", zap.Error(err))
+		writeProblem(w, newProblem(err))
+		return
+	}
+
+	w.Header().Set("Location", "/operations/"+op.ID)
+	w.Header().Set("Preference-Applied", "respond-async")
+	writeJSON(w, http.StatusAccepted, op)
+}
+
+// prefersAsync reports whether the client asked for the action to be processed
+// in the background, per RFC 7240, e.g. "Prefer: respond-async, wait=10".
+func prefersAsync(h http.Header) bool {
+	for _, v := range h.Values("Prefer") {
+		for _, pref := range strings.Split(v, ",") {
+			token := strings.TrimSpace(strings.SplitN(pref, ";", 2)[0])
+			if strings.EqualFold(token, "respond-async") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// requestContext exposes the request's headers as metadata, and its remote
+// address as peer, like gRPC does.
+func requestContext(req *http.Request) context.Context {
+	ctx := metadata.NewIncomingContext(req.Context(), headerMetadata(req.Header))
+	if addr, err := net.ResolveTCPAddr("tcp", req.RemoteAddr); err == nil {
+		ctx = peer.NewContext(ctx, &peer.Peer{Addr: addr})
+	}
+	return ctx
+}
+
+// headerMetadata exposes HTTP headers as request metadata, like gRPC does.
+func headerMetadata(h http.Header) metadata.MD {
+	md := make(metadata.MD, len(h))
+	for k, vs := range h {
+		md.Append(k, vs...)
+	}
+	return md
+}
+
+const problemContentType = "application/problem+json"
+
+// partialFailureHeader reports a group of processors an action was fanned out
+// to which failed to process it, e.g. "Partial-Failure: push; code=Unavailable".
+const partialFailureHeader = "Partial-Failure"
+
+func partialFailureValues(failures []*PartialFailure) []string {
+	values := make([]string, len(failures))
+	for i, f := range failures {
+		values[i] = fmt.Sprintf("%s; code=%s", f.GetGroup(), codes.Code(f.GetStatus().GetCode()))
+	}
+	return values
+}
+
+// problem describes an error as a RFC 7807 problem details object.
+type problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+
+	// Code is the gRPC status code of the error
+	Code string `json:"code"`
+}
+
+func newProblem(err error) *problem {
+	st := status.Convert(err)
+	code := httpStatusCode(st.Code())
+
+	title := http.StatusText(code)
+	if title == "" {
+		title = st.Code().String()
+	}
+
+	return &problem{
+		Type:   "about:blank",
+		Title:  title,
+		Status: code,
+		Detail: st.Message(),
+		Code:   st.Code().String(),
 	}
 }
 
-func decodeActionFromJSON(r io.ReadCloser) (act Action, err error) {
+func writeProblem(w http.ResponseWriter, p *problem) {
+	b, err := json.Marshal(p)
+	if err != nil {
+		zap.L().Error("unexpected error when encoding problem details", zap.Error(err))
+		http.Error(w, p.Detail, p.Status)
+		return
+	}
+
+	w.Header().Set("Content-Type", problemContentType)
+	w.WriteHeader(p.Status)
+
+	_, err = w.Write(b)
+	if err != nil {
+		zap.L().Error("unexpected error when writing problem details to response body", zap.Error(err))
+	}
+}
+
+func decodeActionFromJSON(r io.ReadCloser, types *TypeRegistry) (act Action, err error) {
 	defer r.Close()
 
 	var b []byte
@@ -73,18 +237,42 @@ func decodeActionFromJSON(r io.ReadCloser) (act Action, err error) {
 		return
 	}
 
-	act.Type = getType(typ)
+	if key, ok := v["partitionKey"]; ok {
+		act.PartitionKey, ok = key.(string)
+		if !ok {
+			err = errors.New("expected field partitionKey to be a string")
+			return
+		}
+	}
+
+	act.TypeName, err = getTypeName(types, typ)
+	if err != nil {
+		return
+	}
+	if legacy, ok := Action_Type_value[act.TypeName]; ok {
+		act.Type = Action_Type(legacy)
+	}
+
 	act.Payload, err = json.Marshal(payload)
 	return
 }
 
-func getType(typ interface{}) Action_Type {
+// getTypeName resolves the type of a JSON action, which is either an action
+// type name or a legacy Action_Type number, to its registered name.
+func getTypeName(types *TypeRegistry, typ interface{}) (string, error) {
 	switch x := typ.(type) {
 	case string:
-		return Action_Type(Action_Type_value[x])
-	case int:
-		return Action_Type(x)
+		if name, ok := types.Lookup(x); ok {
+			return name, nil
+		}
+		return x, nil
+	case float64:
+		name, ok := Action_Type_name[int32(x)]
+		if !ok {
+			return "", fmt.Errorf("unknown legacy action type: %v", x)
+		}
+		return name, nil
 	default:
-		return -1
+		return "", errors.New("expected field type to be a string or number")
 	}
 }
@@ -0,0 +1,212 @@
+package action
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// IdempotencyKeyHeader is the HTTP header, and gRPC metadata key, clients
+// send an idempotency key in. Actions sent again with the same key by the
+// same client get the response of the first one replayed instead of being
+// processed again.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// idempotentCall is the first call made with an idempotency key, whose
+// outcome is replayed to the calls repeating it.
+type idempotentCall struct {
+	fingerprint string
+	done        chan struct{}
+
+	// set once done is closed
+	resp    *ActionResponse
+	err     error
+	retry   bool
+	expires time.Time
+}
+
+// idempotencyStore keeps the outcome of calls made with idempotency keys
+// until ttl after they completed.
+type idempotencyStore struct {
+	ttl time.Duration
+
+	mu    sync.Mutex
+	calls map[string]*idempotentCall
+}
+
+func newIdempotencyStore(ttl time.Duration) *idempotencyStore {
+	return &idempotencyStore{
+		ttl:   ttl,
+		calls: make(map[string]*idempotentCall),
+	}
+}
+
+// do calls f unless a call with the same key was already made, in which case
+// its outcome is replayed, once done. Outcomes which are worth retrying, like
+// the processor being unavailable, aren't kept, so the next call with the key
+// calls f again.
+func (st *idempotencyStore) do(ctx context.Context, key, fingerprint string, f func() (*ActionResponse, error)) (*ActionResponse, error) {
+	for {
+		st.mu.Lock()
+		c, ok := st.calls[key]
+		if ok && !c.expires.IsZero() && time.Now().After(c.expires) {
+			delete(st.calls, key)
+			ok = false
+		}
+
+		if !ok {
+			c = &idempotentCall{fingerprint: fingerprint, done: make(chan struct{})}
+			st.calls[key] = c
+			st.mu.Unlock()
+
+			return st.call(key, c, f)
+		}
+		st.mu.Unlock()
+
+		if c.fingerprint != fingerprint {
+			return nil, status.Error(codes.InvalidArgument, "idempotency key was already used for a different action")
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, statusError(contextError(ctx))
+		case <-c.done:
+		}
+		if c.retry {
+			continue
+		}
+
+		zap.L().Debug("replaying response for idempotency key")
+		return c.resp, c.err
+	}
+}
+
+func (st *idempotencyStore) call(key string, c *idempotentCall, f func() (*ActionResponse, error)) (*ActionResponse, error) {
+	resp, err := f()
+
+	st.mu.Lock()
+	c.resp, c.err = resp, err
+	if isRetryable(err) {
+		c.retry = true
+		delete(st.calls, key)
+	} else {
+		c.expires = time.Now().Add(st.ttl)
+	}
+	st.mu.Unlock()
+
+	close(c.done)
+	return resp, err
+}
+
+// isRetryable reports whether an error is transient, so the same action may
+// succeed when sent again.
+func isRetryable(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.Canceled, codes.ResourceExhausted, codes.Aborted:
+		return true
+	default:
+		return false
+	}
+}
+
+// sweep forgets the outcomes which expired by now.
+func (st *idempotencyStore) sweep(now time.Time) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	for key, c := range st.calls {
+		if !c.expires.IsZero() && now.After(c.expires) {
+			delete(st.calls, key)
+		}
+	}
+}
+
+// run sweeps expired outcomes until ctx is done.
+func (st *idempotencyStore) run(ctx context.Context) {
+	interval := st.ttl / 2
+	if interval < time.Second {
+		interval = time.Second
+	}
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			st.sweep(now)
+		}
+	}
+}
+
+// idempotencyKey returns the idempotency key sent along with the action, if
+// any, scoped to the client which sent it. Clients are told apart by their
+// authorization, or by their IP address when unauthenticated.
+func idempotencyKey(ctx context.Context) (string, bool) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	keys := md.Get(IdempotencyKeyHeader)
+	if len(keys) == 0 || keys[0] == "" {
+		return "", false
+	}
+
+	client := ""
+	if auth := md.Get("authorization"); len(auth) > 0 {
+		sum := sha256.Sum256([]byte(auth[0]))
+		client = "auth:" + hex.EncodeToString(sum[:])
+	} else if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		client = "addr:" + p.Addr.String()
+		if host, _, err := net.SplitHostPort(p.Addr.String()); err == nil {
+			client = "addr:" + host
+		}
+	}
+	return client + "\x00" + keys[0], true
+}
+
+type idempotencyKeyKey struct{}
+
+// withIdempotencyKey marks the actions sent with the returned context as sent
+// with the client scoped idempotency key, see clientDeliveryKey.
+func withIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKeyKey{}, key)
+}
+
+// clientDeliveryKey returns the idempotency key processors are sent the
+// action with, if a client sent it with an idempotency key. It's derived from
+// the client's key, so processors can deduplicate retries of the client along
+// with redeliveries, and from the action, so different actions sent on its
+// behalf, e.g. the steps of a pipeline, don't share it.
+func clientDeliveryKey(ctx context.Context, act *Action) string {
+	key, _ := ctx.Value(idempotencyKeyKey{}).(string)
+	if key == "" {
+		return ""
+	}
+
+	h := sha256.New()
+	h.Write([]byte(key))
+	h.Write([]byte{0})
+	h.Write([]byte(actionFingerprint(act)))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// actionFingerprint identifies the action an idempotency key was first used
+// with, so it can't be reused for a different one.
+func actionFingerprint(act *Action) string {
+	h := sha256.New()
+	h.Write([]byte(TypeName(act)))
+	h.Write([]byte{0})
+	h.Write([]byte(act.GetPartitionKey()))
+	h.Write([]byte{0})
+	h.Write(act.GetPayload())
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,107 @@
+package action
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+const inflightShards = 32
+
+// inflightTable tracks the requests which are awaiting a response from a
+// processor. It's sharded by request id to reduce lock contention between
+// callers of Mux.SendAction and the goroutine receiving responses.
+type inflightTable struct {
+	size   int64
+	shards [inflightShards]inflightShard
+}
+
+type inflightShard struct {
+	mu       sync.Mutex
+	requests map[string]*pendingRequest
+}
+
+func newInflightTable() *inflightTable {
+	t := new(inflightTable)
+	for i := range t.shards {
+		t.shards[i].requests = make(map[string]*pendingRequest)
+	}
+	return t
+}
+
+func (t *inflightTable) shard(id string) *inflightShard {
+	// inlined FNV-1a so picking a shard doesn't allocate
+	h := uint32(2166136261)
+	for i := 0; i < len(id); i++ {
+		h ^= uint32(id[i])
+		h *= 16777619
+	}
+	return &t.shards[h%inflightShards]
+}
+
+// add tracks the given request until it's taken.
+func (t *inflightTable) add(p *pendingRequest) {
+	s := t.shard(p.req.GetId())
+
+	s.mu.Lock()
+	s.requests[p.req.GetId()] = p
+	s.mu.Unlock()
+
+	atomic.AddInt64(&t.size, 1)
+}
+
+// take removes and returns the request with the given id. Only one caller
+// will ever receive a given request, so it's safe for them to complete it.
+func (t *inflightTable) take(id string) *pendingRequest {
+	s := t.shard(id)
+
+	s.mu.Lock()
+	p, ok := s.requests[id]
+	if ok {
+		delete(s.requests, id)
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		return nil
+	}
+	atomic.AddInt64(&t.size, -1)
+	return p
+}
+
+// takeAll removes and returns every tracked request.
+func (t *inflightTable) takeAll() []*pendingRequest {
+	var ps []*pendingRequest
+	for i := range t.shards {
+		s := &t.shards[i]
+
+		s.mu.Lock()
+		for id, p := range s.requests {
+			ps = append(ps, p)
+			delete(s.requests, id)
+		}
+		s.mu.Unlock()
+	}
+
+	atomic.AddInt64(&t.size, -int64(len(ps)))
+	return ps
+}
+
+// snapshot returns every tracked request without removing them.
+func (t *inflightTable) snapshot() []*pendingRequest {
+	var ps []*pendingRequest
+	for i := range t.shards {
+		s := &t.shards[i]
+
+		s.mu.Lock()
+		for _, p := range s.requests {
+			ps = append(ps, p)
+		}
+		s.mu.Unlock()
+	}
+	return ps
+}
+
+// len returns the number of tracked requests.
+func (t *inflightTable) len() int {
+	return int(atomic.LoadInt64(&t.size))
+}
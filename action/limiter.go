@@ -0,0 +1,62 @@
+package action
+
+import (
+	"context"
+	"sync"
+)
+
+// limiter caps how many actions are outstanding at once. Its limit can be
+// changed at any time, e.g. when a processor registers, and 0 means no limit.
+type limiter struct {
+	mu     sync.Mutex
+	limit  int
+	active int
+
+	// wake is closed, and replaced, whenever a slot may have freed up
+	wake chan struct{}
+}
+
+func newLimiter() *limiter {
+	return &limiter{wake: make(chan struct{})}
+}
+
+// acquire waits for a free slot or ctx to be done.
+func (l *limiter) acquire(ctx context.Context) error {
+	for {
+		l.mu.Lock()
+		if l.limit <= 0 || l.active < l.limit {
+			l.active++
+			l.mu.Unlock()
+			return nil
+		}
+		wake := l.wake
+		l.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return contextError(ctx)
+		case <-wake:
+		}
+	}
+}
+
+func (l *limiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.active--
+	l.wakeAll()
+}
+
+func (l *limiter) setLimit(n int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.limit = n
+	l.wakeAll()
+}
+
+func (l *limiter) wakeAll() {
+	close(l.wake)
+	l.wake = make(chan struct{})
+}
@@ -2,68 +2,369 @@ package action
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
-	"github.com/patrickmn/go-cache"
 	"go.uber.org/zap"
+	"google.golang.org/grpc/backoff"
 )
 
-// Mux multiplexes a Actions over a single Processor_ProcessActionsClient.
+var (
+	errStreamUnavailable = errors.New("processor stream is not established")
+	errMuxClosed         = errors.New("mux is closed")
+)
+
+// Sender sends Actions to a processor and returns its response.
+type Sender interface {
+	SendAction(ctx context.Context, act *Action) (*Action, error)
+}
+
+// InFlightPolicy determines what happens to requests which are still awaiting
+// a response when the processor stream terminates.
+type InFlightPolicy int
+
+const (
+	// FailInFlight fails every in-flight request as soon as the stream terminates.
+	FailInFlight InFlightPolicy = iota
+
+	// ResendInFlight keeps in-flight requests around and re-sends them
+	// once the stream has been re-established.
+	ResendInFlight
+)
+
+// QueueFullPolicy determines what happens when an action is sent while the
+// send queue of a Mux is full.
+type QueueFullPolicy int
+
+const (
+	// BlockWhenFull blocks the sender until there's room in the queue or
+	// its context is done.
+	BlockWhenFull QueueFullPolicy = iota
+
+	// FailWhenFull immediately fails the send with ErrQueueFull.
+	FailWhenFull
+)
+
+// processorStream is the half of a processor stream the Mux uses, which is
+// the same whether the Gateway or the processor opened the stream.
+type processorStream interface {
+	Send(*ProcessorRequest) error
+	Recv() (*ProcessorResponse, error)
+}
+
+// Mux multiplexes Actions over a single processor stream. Either it opens a
+// Processor_ProcessActionsClient from a ProcessorClient and re-establishes it
+// whenever it terminates, or it's given a stream the processor opened, see
+// NewStreamMux.
 type Mux struct {
-	stream Processor_ProcessActionsClient
-	cache  *cache.Cache
+	client   ProcessorClient
+	inflight *inflightTable
+	backoff  backoff.Config
+	policy   InFlightPolicy
+
+	// all sends go through queue since a stream isn't safe for concurrent
+	// use, so a single goroutine writes everything to the stream.
+	queue       chan *pendingRequest
+	queueSize   int
+	queuePolicy QueueFullPolicy
+	maxBatch    int
+
+	// streams hands the writer goroutine newly established streams and
+	// nil when they terminate.
+	streams chan processorStream
+
+	// sequencer is only set when actions sharing a partition key are ordered
+	sequencer *keySequencer
+
+	// limit caps outstanding actions at the max concurrency the processor
+	// registered with, and registration holds that *ProcessorInfo.
+	limit        *limiter
+	registration atomic.Value
+	onRegister   func(*Mux, *ProcessorInfo)
+
+	// log is only set when actions are durably logged until acknowledged
+	log *ActionLog
+
+	closed int32
+	done   <-chan struct{}
+	cancel context.CancelFunc
 }
 
 type MuxOption func(*Mux)
 
-func WithCache(cache *cache.Cache) MuxOption {
+// WithBackoff configures the backoff used between attempts at
+// re-establishing the processor stream.
+func WithBackoff(cfg backoff.Config) MuxOption {
 	return func(m *Mux) {
-		m.cache = cache
+		m.backoff = cfg
+	}
+}
+
+// WithInFlightPolicy configures how in-flight requests are handled
+// when the processor stream terminates. The default is FailInFlight.
+func WithInFlightPolicy(policy InFlightPolicy) MuxOption {
+	return func(m *Mux) {
+		m.policy = policy
+	}
+}
+
+// WithSendQueueSize configures how many actions can be queued up
+// to be sent to the processor. The default is 1024.
+func WithSendQueueSize(size int) MuxOption {
+	return func(m *Mux) {
+		m.queueSize = size
+	}
+}
+
+// WithQueueFullPolicy configures what happens when the send queue is full.
+// The default is BlockWhenFull.
+func WithQueueFullPolicy(policy QueueFullPolicy) MuxOption {
+	return func(m *Mux) {
+		m.queuePolicy = policy
+	}
+}
+
+// WithMaxBatchSize configures the max number of queued actions written to
+// the stream back to back, before checking for stream changes again.
+// The default is 64.
+func WithMaxBatchSize(size int) MuxOption {
+	return func(m *Mux) {
+		m.maxBatch = size
+	}
+}
+
+// WithOrderedDelivery makes actions which share a partition key get sent to
+// the processor, and have their responses released, strictly in the order
+// SendAction was called. Actions with different keys still flow in parallel.
+// Re-sent in-flight actions, see ResendInFlight, aren't guaranteed to be ordered.
+func WithOrderedDelivery() MuxOption {
+	return func(m *Mux) {
+		m.sequencer = newKeySequencer()
+	}
+}
+
+// WithRegistrationHandler configures a func called with the registration a
+// processor sends at the start of a stream, and with nil once that stream
+// terminates.
+func WithRegistrationHandler(f func(m *Mux, info *ProcessorInfo)) MuxOption {
+	return func(m *Mux) {
+		m.onRegister = f
+	}
+}
+
+// WithActionLog makes the Mux durably log every action before sending it,
+// until the processor responds to it. Actions which failed to be delivered,
+// even by a previous gateway process, are redelivered in the background with
+// the same idempotency key, so processors receive them at least once. Muxes
+// may share a log, e.g. the ones streaming to the same processor.
+func WithActionLog(l *ActionLog) MuxOption {
+	return func(m *Mux) {
+		m.log = l
+	}
+}
+
+// NewMux returns a Mux which streams actions to the given processor until
+// ctx is done.
+func NewMux(ctx context.Context, client ProcessorClient, opts ...MuxOption) *Mux {
+	m, ctx := newMux(ctx, opts...)
+	m.client = client
+
+	go m.writeActions(ctx)
+	go m.run(ctx)
+	if m.log != nil {
+		go m.redeliver(ctx)
 	}
+
+	return m
 }
 
-func NewMux(stream Processor_ProcessActionsClient, opts ...MuxOption) *Mux {
+// NewStreamMux returns a Mux which streams actions over a stream the
+// processor opened, e.g. through ProcessorGateway.Connect, after registering
+// with info, until either the stream terminates or ctx is done. Since the
+// stream can't be re-established, the Mux is closed once it terminates, see Done.
+func NewStreamMux(ctx context.Context, stream ProcessorGateway_ConnectServer, info *ProcessorInfo, opts ...MuxOption) *Mux {
+	m, ctx := newMux(ctx, opts...)
+
+	go m.writeActions(ctx)
+	go m.serve(ctx, stream, info)
+
+	return m
+}
+
+func newMux(ctx context.Context, opts ...MuxOption) (*Mux, context.Context) {
 	m := &Mux{
-		stream: stream,
-		cache:  cache.New(1*time.Second, 2*time.Second),
+		inflight:  newInflightTable(),
+		backoff:   backoff.DefaultConfig,
+		policy:    FailInFlight,
+		queueSize: 1024,
+		maxBatch:  64,
+		streams:   make(chan processorStream),
+		limit:     newLimiter(),
 	}
+	m.registration.Store((*ProcessorInfo)(nil))
 
 	for _, opt := range opts {
 		opt(m)
 	}
+	if m.maxBatch < 1 {
+		m.maxBatch = 1
+	}
+	m.queue = make(chan *pendingRequest, m.queueSize)
+
+	ctx, m.cancel = context.WithCancel(ctx)
+	m.done = ctx.Done()
+	return m, ctx
+}
 
-	go m.receiveActions()
+type pendingRequest struct {
+	req        *ProcessorRequest
+	responseCh chan *ProcessorResponse
 
-	return m
+	// dequeued is only ever accessed by the writer goroutine
+	dequeued bool
+}
+
+// SendAction sends the given action to the processor and waits for its response.
+// A nil Action and nil error are returned when the processor processed the
+// action without returning any content.
+func (m *Mux) SendAction(ctx context.Context, act *Action) (*Action, error) {
+	if atomic.LoadInt32(&m.closed) == 1 {
+		return nil, fmt.Errorf("%w: %v", ErrProcessorUnavailable, errMuxClosed)
+	}
+
+	key := act.GetPartitionKey()
+	if m.sequencer == nil || key == "" {
+		return m.send(ctx, act, deliveryFromContext(ctx), nil)
+	}
+
+	prev, t := m.sequencer.next(key)
+	defer m.sequencer.done(key, prev, t)
+
+	// wait for the previous action with the same key to be queued
+	// since the queue is the order actions are sent to the processor in
+	if prev != nil {
+		select {
+		case <-ctx.Done():
+			return nil, contextError(ctx)
+		case <-prev.queued:
+		}
+	}
+
+	resp, err := m.send(ctx, act, deliveryFromContext(ctx), t.markQueued)
+
+	// and for it to be released before releasing this response
+	if prev != nil {
+		select {
+		case <-ctx.Done():
+			return nil, contextError(ctx)
+		case <-prev.released:
+		}
+	}
+	return resp, err
 }
 
-func (m *Mux) SendAction(ctx context.Context, act *Action) *Action {
+// delivery identifies an attempt at delivering an action, whose attempts
+// share an idempotency key.
+type delivery struct {
+	idempotencyKey string
+	attempt        uint32
+}
+
+type deliveryKey struct{}
+
+// withDelivery marks actions sent with the returned context as the given
+// attempt at delivering them.
+func withDelivery(ctx context.Context, d delivery) context.Context {
+	return context.WithValue(ctx, deliveryKey{}, d)
+}
+
+func deliveryFromContext(ctx context.Context) delivery {
+	d, _ := ctx.Value(deliveryKey{}).(delivery)
+	return d
+}
+
+// send queues the action to be sent to the processor, calling queued, if
+// non-nil, once it has been, and then waits for its response. First attempts
+// at delivering actions get the id of their request as idempotency key, and
+// actions are logged first if the Mux has an ActionLog.
+func (m *Mux) send(ctx context.Context, act *Action, d delivery, queued func()) (_ *Action, err error) {
 	uid, err := uuid.NewRandom()
 	if err != nil {
-		return nil
+		return nil, err
 	}
 
+	err = m.limit.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer m.limit.release()
+
 	id := uid.String()
+	if d.idempotencyKey == "" {
+		d.idempotencyKey = id
+	}
+	if d.attempt == 0 {
+		d.attempt = 1
+	}
+	if m.log != nil {
+		err = m.log.append(d.idempotencyKey, act)
+		if err != nil {
+			zap.L().Error("failed to log action", zap.String("id", id), zap.Error(err))
+			return nil, fmt.Errorf("failed to log action: %w", err)
+		}
+	}
+
+	// the processor responding with anything, even an error, acknowledges
+	// the action, while it's left to be redelivered otherwise
+	acked := false
+	if m.log != nil {
+		defer func() {
+			if acked {
+				m.log.ack(d.idempotencyKey)
+				return
+			}
+			m.log.release(d.idempotencyKey)
+			if err != nil {
+				err = &redeliveryError{err: err}
+			}
+		}()
+	}
+
 	req := &ProcessorRequest{
-		Id:     id,
-		Action: act,
+		Id:             id,
+		Action:         act,
+		IdempotencyKey: d.idempotencyKey,
+		Attempt:        d.attempt,
 	}
 	responseCh := make(chan *ProcessorResponse, 1)
 
-	m.set(ctx, id, responseCh)
-	err = m.sendAction(req)
+	p := &pendingRequest{req: req, responseCh: responseCh}
+	m.inflight.add(p)
+
+	err = m.enqueue(ctx, p)
 	if err != nil {
-		zap.L().Error("unexpected error when sending action to processor", zap.Error(err))
-		return nil
+		m.inflight.take(id)
+		zap.L().Error("failed to queue action for processor", zap.String("id", id), zap.Error(err))
+		return nil, err
+	}
+	if queued != nil {
+		queued()
 	}
-	zap.L().Debug("sent request to processor", zap.String("id", id))
+	zap.L().Debug("queued request for processor", zap.String("id", id))
 
 	select {
 	case <-ctx.Done():
-		// TODO: return error here
-		return nil
-	case resp := <-responseCh:
+		m.inflight.take(id)
+		return nil, contextError(ctx)
+	case resp, ok := <-responseCh:
+		if !ok {
+			zap.L().Error("processor stream terminated before receiving response", zap.String("id", id))
+			return nil, ErrProcessorUnavailable
+		}
 		respId := resp.GetId()
 
 		zap.L().Debug("received response from processor goroutine", zap.String("id", respId))
@@ -73,65 +374,323 @@ func (m *Mux) SendAction(ctx context.Context, act *Action) *Action {
 				zap.String("reqId", id),
 				zap.String("respId", respId),
 			)
-			return nil
+			return nil, fmt.Errorf("%w: response id %q doesn't match request id %q", ErrProtocolViolation, respId, id)
 		}
 
 		switch x := resp.GetBody().(type) {
 		case *ProcessorResponse_Content:
+			acked = true
 			return &Action{
 				Payload: x.Content,
-			}
+			}, nil
 		case *ProcessorResponse_WasProcessed:
-			return nil
+			acked = true
+			return nil, nil
+		case *ProcessorResponse_Error:
+			acked = true
+			return nil, newProcessorError(x.Error)
 		default:
 			zap.L().Error("unexpected processor response body", zap.String("id", respId))
-			return nil
+			return nil, fmt.Errorf("%w: unexpected response body", ErrProtocolViolation)
 		}
 	}
 }
 
-func (m *Mux) set(ctx context.Context, id string, responseCh chan<- *ProcessorResponse) {
-	// zero expiration means use cache defined default expiration
-	var expiration time.Duration
+// redeliveryInterval is how often a Mux with an ActionLog looks for actions
+// to redeliver, and redeliveryTimeout how long each redelivery may take.
+const (
+	redeliveryInterval = time.Second
+	redeliveryTimeout  = 30 * time.Second
+	maxRedeliveries    = 64
+)
 
-	deadline, ok := ctx.Deadline()
-	if ok {
-		// if expiration is negative the item will be cached forever
-		if expiration = deadline.Sub(time.Now()); expiration < 0 {
-			expiration = 0
+// redeliver keeps redelivering the logged actions nobody is delivering until
+// ctx is done. Redelivered actions aren't ordered, see WithOrderedDelivery.
+func (m *Mux) redeliver(ctx context.Context) {
+	ticker := time.NewTicker(redeliveryInterval)
+	defer ticker.Stop()
+
+	// closing Muxes leave redelivering to the others sharing the log
+	for atomic.LoadInt32(&m.closed) == 0 {
+		var wg sync.WaitGroup
+		for _, c := range m.log.claim(maxRedeliveries) {
+			wg.Add(1)
+			go func(c claimedAction) {
+				defer wg.Done()
+
+				sendCtx, cancel := context.WithTimeout(ctx, redeliveryTimeout)
+				defer cancel()
+
+				_, err := m.send(sendCtx, c.act, delivery{idempotencyKey: c.key, attempt: c.attempt}, nil)
+				if err != nil {
+					zap.L().Debug("failed to redeliver action", zap.String("key", c.key), zap.Error(err))
+				}
+			}(c)
 		}
+		wg.Wait()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// InFlight returns the number of actions awaiting a response from the processor.
+func (m *Mux) InFlight() int {
+	return m.inflight.len()
+}
+
+// Done returns a channel which is closed once the Mux stops streaming
+// actions to the processor.
+func (m *Mux) Done() <-chan struct{} {
+	return m.done
+}
+
+// Registration returns what the processor registered with at the start of
+// the current stream, or nil if it hasn't.
+func (m *Mux) Registration() *ProcessorInfo {
+	return m.registration.Load().(*ProcessorInfo)
+}
+
+// register applies the registration of the processor, or clears it when
+// info is nil.
+func (m *Mux) register(info *ProcessorInfo) {
+	if info == nil && m.Registration() == nil {
+		return
 	}
 
-	m.cache.Set(id, responseCh, expiration)
+	m.registration.Store(info)
+	m.limit.setLimit(int(info.GetMaxConcurrency()))
+	if m.onRegister != nil {
+		m.onRegister(m, info)
+	}
 }
 
-func (m *Mux) sendAction(req *ProcessorRequest) error {
-	return m.stream.Send(req)
+// Close stops the Mux from accepting new actions and waits for the in-flight
+// ones to complete, or ctx to be done, before terminating the processor stream.
+func (m *Mux) Close(ctx context.Context) error {
+	atomic.StoreInt32(&m.closed, 1)
+	defer m.cancel()
+
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+
+	for m.InFlight() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
 }
 
-func (m *Mux) receiveActions() {
+// failPending closes the response channel of every pending request.
+func (m *Mux) failPending() {
+	for _, p := range m.inflight.takeAll() {
+		close(p.responseCh)
+	}
+}
+
+func (m *Mux) enqueue(ctx context.Context, p *pendingRequest) error {
+	if m.queuePolicy == FailWhenFull {
+		select {
+		case m.queue <- p:
+			return nil
+		default:
+			return ErrQueueFull
+		}
+	}
+
+	select {
+	case <-ctx.Done():
+		return contextError(ctx)
+	case m.queue <- p:
+		return nil
+	}
+}
+
+// writeActions is the only goroutine which ever writes to a processor stream.
+func (m *Mux) writeActions(ctx context.Context) {
+	var stream processorStream
+	batch := make([]*pendingRequest, 0, m.maxBatch)
+
 	for {
-		resp, err := m.stream.Recv()
-		if err != nil {
-			// TODO: determine what error is
-			zap.L().Error("unexpected error when receiving processor response", zap.Error(err))
+		select {
+		case <-ctx.Done():
+			return
+		case stream = <-m.streams:
+			if stream != nil && m.policy == ResendInFlight {
+				m.resendPending(stream)
+			}
 			continue
+		case p := <-m.queue:
+			batch = append(batch[:0], p)
 		}
 
-		go func() {
-			v, ok := m.cache.Get(resp.GetId())
-			if !ok {
-				// cache expired so can't relay response to client
-				return
+		// opportunistically grab whatever else is already queued up so it's all
+		// written back to back, which lets the transport coalesce the writes.
+	drain:
+		for len(batch) < m.maxBatch {
+			select {
+			case p := <-m.queue:
+				batch = append(batch, p)
+			default:
+				break drain
+			}
+		}
+
+		for _, p := range batch {
+			p.dequeued = true
+
+			if stream == nil {
+				m.sendFailed(p, errStreamUnavailable)
+				continue
 			}
 
-			respCh, ok := v.(chan<- *ProcessorResponse)
-			if !ok {
+			err := stream.Send(p.req)
+			if err != nil {
+				zap.L().Error("unexpected error when sending action to processor", zap.Error(err))
+				// the receiving goroutine will notice the stream terminated
+				stream = nil
+				m.sendFailed(p, err)
+				continue
+			}
+			zap.L().Debug("sent request to processor", zap.String("id", p.req.GetId()))
+		}
+	}
+}
+
+// sendFailed fails the given request unless it'll be resent once the stream
+// is re-established.
+func (m *Mux) sendFailed(p *pendingRequest, err error) {
+	if m.policy == ResendInFlight {
+		zap.L().Debug("failed to send request to processor, will resend", zap.String("id", p.req.GetId()), zap.Error(err))
+		return
+	}
+
+	if m.inflight.take(p.req.GetId()) != nil {
+		close(p.responseCh)
+	}
+}
+
+// resendPending re-sends every pending request which has already been taken
+// off of the queue over the given stream.
+func (m *Mux) resendPending(stream processorStream) {
+	for _, p := range m.inflight.snapshot() {
+		if !p.dequeued {
+			continue
+		}
+
+		err := stream.Send(p.req)
+		if err != nil {
+			zap.L().Error("unexpected error when resending action to processor", zap.Error(err))
+			return
+		}
+		zap.L().Debug("resent request to processor", zap.String("id", p.req.GetId()))
+	}
+}
+
+// run keeps a processor stream established until ctx is done.
+func (m *Mux) run(ctx context.Context) {
+	defer m.failPending()
+
+	for retries := 0; ; {
+		stream, err := m.client.ProcessActions(ctx)
+		if err != nil {
+			zap.L().Error("unexpected error when opening processor stream", zap.Error(err))
+			if !sleep(ctx, backoffDelay(m.backoff, retries)) {
 				return
 			}
+			retries++
+			continue
+		}
+		retries = 0
+		zap.L().Info("established processor stream")
 
-			respCh <- resp
-			close(respCh)
-		}()
+		if !m.setStream(ctx, stream) {
+			return
+		}
+
+		err = m.receiveActions(stream)
+		zap.L().Error("processor stream terminated", zap.Error(err))
+		m.register(nil)
+
+		if !m.setStream(ctx, nil) {
+			return
+		}
+
+		if m.policy == FailInFlight {
+			m.failPending()
+		}
+		if !sleep(ctx, backoffDelay(m.backoff, retries)) {
+			return
+		}
+	}
+}
+
+// serve streams actions over the given stream until it terminates, at which
+// point the Mux is closed since the stream can't be re-established.
+func (m *Mux) serve(ctx context.Context, stream processorStream, info *ProcessorInfo) {
+	defer m.failPending()
+	defer m.cancel()
+	defer m.register(nil)
+
+	m.register(info)
+	if !m.setStream(ctx, stream) {
+		return
+	}
+
+	err := m.receiveActions(stream)
+	zap.L().Info("processor stream terminated", zap.Error(err))
+	atomic.StoreInt32(&m.closed, 1)
+}
+
+// setStream hands the writer goroutine the stream it should be writing to.
+func (m *Mux) setStream(ctx context.Context, stream processorStream) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case m.streams <- stream:
+		return true
+	}
+}
+
+// receiveActions relays responses to their pending requests until the
+// stream terminates.
+func (m *Mux) receiveActions(stream processorStream) error {
+	for first := true; ; first = false {
+		resp, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+
+		if info := resp.GetRegistration(); info != nil {
+			if !first {
+				zap.L().Warn("ignoring processor registration sent after the start of the stream")
+				continue
+			}
+			zap.L().Info(
+				"processor registered",
+				zap.String("name", info.GetName()),
+				zap.String("version", info.GetVersion()),
+				zap.Strings("types", info.GetActionTypes()),
+				zap.Uint32("maxConcurrency", info.GetMaxConcurrency()),
+			)
+			m.register(info)
+			continue
+		}
+
+		p := m.inflight.take(resp.GetId())
+		if p == nil {
+			// request was canceled or already failed so can't relay response to client
+			zap.L().Debug("dropping response for unknown request", zap.String("id", resp.GetId()))
+			continue
+		}
+
+		p.responseCh <- resp
+		close(p.responseCh)
 	}
 }
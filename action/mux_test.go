@@ -0,0 +1,134 @@
+package action
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// fakeProcessor is a ProcessorClient whose single stream hands the test every
+// request sent over it, and relays whatever the test responds with.
+type fakeProcessor struct {
+	requests  chan *ProcessorRequest
+	responses chan *ProcessorResponse
+}
+
+func newFakeProcessor() *fakeProcessor {
+	return &fakeProcessor{
+		requests:  make(chan *ProcessorRequest, 16),
+		responses: make(chan *ProcessorResponse, 16),
+	}
+}
+
+func (p *fakeProcessor) ProcessActions(ctx context.Context, _ ...grpc.CallOption) (Processor_ProcessActionsClient, error) {
+	return &fakeProcessorStream{ctx: ctx, p: p}, nil
+}
+
+func (p *fakeProcessor) Describe(context.Context, *emptypb.Empty, ...grpc.CallOption) (*ProcessorInfo, error) {
+	return &ProcessorInfo{}, nil
+}
+
+func (p *fakeProcessor) request(t *testing.T) *ProcessorRequest {
+	t.Helper()
+
+	select {
+	case req := <-p.requests:
+		return req
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a request")
+		return nil
+	}
+}
+
+type fakeProcessorStream struct {
+	grpc.ClientStream
+
+	ctx context.Context
+	p   *fakeProcessor
+}
+
+func (s *fakeProcessorStream) Send(req *ProcessorRequest) error {
+	s.p.requests <- req
+	return nil
+}
+
+func (s *fakeProcessorStream) Recv() (*ProcessorResponse, error) {
+	select {
+	case <-s.ctx.Done():
+		return nil, s.ctx.Err()
+	case resp := <-s.p.responses:
+		return resp, nil
+	}
+}
+
+func TestMuxLeavesUnansweredActionsToBeRedelivered(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	l := openTestLog(t, t.TempDir())
+	p := newFakeProcessor()
+	// requests wait for the stream to be established rather than failing
+	m := NewMux(ctx, p, WithActionLog(l), WithInFlightPolicy(ResendInFlight))
+
+	sendCtx, cancelSend := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancelSend()
+	_, err := m.SendAction(sendCtx, &Action{TypeName: "HELLO", Payload: []byte(`"a"`)})
+
+	var re *redeliveryError
+	if !errors.As(err, &re) || !errors.Is(err, ErrDeadlineExceeded) {
+		t.Fatalf("got error %v, want the deadline exceeded leaving the action to be redelivered", err)
+	}
+	if n := l.Len(); n != 1 {
+		t.Fatalf("got %d logged actions, want the unanswered one", n)
+	}
+
+	first := p.request(t)
+	redelivered := p.request(t)
+	if redelivered.GetIdempotencyKey() != first.GetIdempotencyKey() || redelivered.GetAttempt() != 2 {
+		t.Fatalf("redelivered %+v, want attempt 2 of %+v", redelivered, first)
+	}
+
+	p.responses <- &ProcessorResponse{
+		Id:   redelivered.GetId(),
+		Body: &ProcessorResponse_WasProcessed{WasProcessed: new(emptypb.Empty)},
+	}
+	for deadline := time.Now().Add(5 * time.Second); l.Len() != 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("redelivered action wasn't acked once responded to")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMuxAcksAnsweredActions(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	l := openTestLog(t, t.TempDir())
+	p := newFakeProcessor()
+	// requests wait for the stream to be established rather than failing
+	m := NewMux(ctx, p, WithActionLog(l), WithInFlightPolicy(ResendInFlight))
+
+	go func() {
+		req := <-p.requests
+		p.responses <- &ProcessorResponse{
+			Id:   req.GetId(),
+			Body: &ProcessorResponse_Content{Content: []byte(`"b"`)},
+		}
+	}()
+
+	resp, err := m.SendAction(ctx, &Action{TypeName: "HELLO", Payload: []byte(`"a"`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(resp.GetPayload()) != `"b"` {
+		t.Errorf("got response %q, want \"b\"", resp.GetPayload())
+	}
+	if n := l.Len(); n != 0 {
+		t.Errorf("got %d logged actions, want none once acked", n)
+	}
+}
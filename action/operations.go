@@ -0,0 +1,336 @@
+package action
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// OperationStatus is the status of an Operation.
+type OperationStatus string
+
+const (
+	OperationPending   OperationStatus = "pending"
+	OperationSucceeded OperationStatus = "succeeded"
+	OperationFailed    OperationStatus = "failed"
+
+	// OperationAccepted is an action logged durably which is redelivered
+	// until its processor responds; its result isn't recorded.
+	OperationAccepted OperationStatus = "accepted"
+)
+
+// Operation is an action processed in the background, see Gateway.Submit.
+type Operation struct {
+	ID     string          `json:"id"`
+	Status OperationStatus `json:"status"`
+
+	// Result is the content the action was responded with, if any
+	Result          json.RawMessage `json:"result,omitempty"`
+	PartialFailures []string        `json:"partialFailures,omitempty"`
+	Error           *problem        `json:"error,omitempty"`
+
+	CreatedAt   time.Time  `json:"createdAt"`
+	CompletedAt *time.Time `json:"completedAt,omitempty"`
+
+	callbackURL string
+}
+
+// callbackBackoff spaces out redelivering the result of an operation to a
+// callback URL which failed to accept it.
+var callbackBackoff = backoff.Config{
+	BaseDelay:  1 * time.Second,
+	Multiplier: 2,
+	Jitter:     0.2,
+	MaxDelay:   30 * time.Second,
+}
+
+const maxCallbackAttempts = 5
+
+// operationStore keeps operations until ttl after they've completed.
+type operationStore struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	ops     map[string]*Operation
+	expires map[string]time.Time
+}
+
+func newOperationStore(ttl time.Duration) *operationStore {
+	return &operationStore{
+		ttl:     ttl,
+		ops:     make(map[string]*Operation),
+		expires: make(map[string]time.Time),
+	}
+}
+
+// add stores a copy of the operation.
+func (st *operationStore) add(op *Operation) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	cp := *op
+	st.ops[op.ID] = &cp
+}
+
+// get returns a copy of the operation, which may still be pending.
+func (st *operationStore) get(id string) (Operation, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	op, ok := st.ops[id]
+	if !ok {
+		return Operation{}, false
+	}
+	return *op, true
+}
+
+// complete records the outcome of an operation, returning a copy of it.
+func (st *operationStore) complete(id string, resp *ActionResponse, err error) Operation {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	op := st.ops[id]
+	now := time.Now()
+	op.CompletedAt = &now
+	st.expires[id] = now.Add(st.ttl)
+
+	if err != nil {
+		op.Status = OperationFailed
+		op.Error = newProblem(err)
+		return *op
+	}
+
+	op.Status = OperationSucceeded
+	if resp.GetAccepted() != nil {
+		op.Status = OperationAccepted
+	}
+	op.PartialFailures = partialFailureValues(resp.GetPartialFailures())
+	if content := resp.GetContent(); content != nil {
+		op.Result = content
+		if !json.Valid(content) {
+			op.Result, _ = json.Marshal(string(content))
+		}
+	}
+	return *op
+}
+
+// sweep forgets the operations which expired by now.
+func (st *operationStore) sweep(now time.Time) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	for id, expires := range st.expires {
+		if now.After(expires) {
+			delete(st.ops, id)
+			delete(st.expires, id)
+		}
+	}
+}
+
+// run sweeps expired operations until ctx is done.
+func (st *operationStore) run(ctx context.Context) {
+	interval := st.ttl / 2
+	if interval < time.Second {
+		interval = time.Second
+	}
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			st.sweep(now)
+		}
+	}
+}
+
+// Submit processes the action in the background instead of waiting for it,
+// returning an Operation whose outcome can be polled for with Operation. Once
+// done, it's also POSTed as JSON to callbackURL, if set.
+func (s *Gateway) Submit(ctx context.Context, req *ActionRequest, callbackURL string) (*Operation, error) {
+	if callbackURL != "" {
+		err := s.checkCallbackURL(callbackURL)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	uid, err := uuid.NewRandom()
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	op := Operation{
+		ID:          uid.String(),
+		Status:      OperationPending,
+		CreatedAt:   time.Now(),
+		callbackURL: callbackURL,
+	}
+	s.operations.add(&op)
+
+	// the action outlives the request it was submitted with, but not the
+	// processors, nor the async timeout. The idempotency key is scoped to the
+	// client before detaching, since the peer doesn't carry over.
+	key, _ := idempotencyKey(ctx)
+	bgCtx, cancel := context.WithTimeout(s.ctx, s.asyncTimeout)
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		bgCtx = metadata.NewIncomingContext(bgCtx, md)
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		bgCtx = peer.NewContext(bgCtx, p)
+	}
+
+	go func() {
+		defer cancel()
+
+		resp, err := s.processIdempotent(bgCtx, req, key)
+		done := s.operations.complete(op.ID, resp, err)
+		if done.callbackURL != "" {
+			s.deliverCallback(&done)
+		}
+	}()
+
+	return &op, nil
+}
+
+// Operation returns the operation with the given id, unless it's unknown or
+// expired.
+func (s *Gateway) Operation(id string) (*Operation, error) {
+	op, ok := s.operations.get(id)
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "no operation %s", id)
+	}
+	return &op, nil
+}
+
+// checkCallbackURL checks that the outcome of an operation may be POSTed to
+// the callback URL, see WithCallbackHosts. Host names which resolve to
+// addresses which aren't allowed are refused when dialing them instead.
+func (s *Gateway) checkCallbackURL(callbackURL string) error {
+	u, err := url.Parse(callbackURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return status.Errorf(codes.InvalidArgument, "invalid callback url: %s", callbackURL)
+	}
+
+	host := strings.ToLower(u.Hostname())
+	if len(s.callbackHosts) > 0 {
+		if !s.callbackHosts[host] {
+			return status.Errorf(codes.InvalidArgument, "callback host %s isn't allowed", host)
+		}
+		return nil
+	}
+	if ip := net.ParseIP(host); ip != nil && !publicIP(ip) {
+		return status.Errorf(codes.InvalidArgument, "callback host %s isn't allowed", host)
+	}
+	return nil
+}
+
+// cgnat is the shared address space of carrier-grade NATs, RFC 6598
+var cgnat = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// publicIP reports whether ip is neither a loopback, private, link-local
+// nor otherwise special address.
+func publicIP(ip net.IP) bool {
+	return !ip.IsLoopback() &&
+		!ip.IsPrivate() &&
+		!ip.IsLinkLocalUnicast() &&
+		!ip.IsLinkLocalMulticast() &&
+		!ip.IsInterfaceLocalMulticast() &&
+		!ip.IsMulticast() &&
+		!ip.IsUnspecified() &&
+		!cgnat.Contains(ip)
+}
+
+// newCallbackClient returns the client operations are POSTed to callback URLs
+// with. Unless public is false, it refuses to connect to addresses which
+// aren't public, also when host names resolve to them. Redirects are never
+// followed, since they could lead anywhere.
+func newCallbackClient(public bool) *http.Client {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	if public {
+		dialer.Control = func(_, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+				return fmt.Errorf("callback address %s isn't allowed", host)
+			}
+			return nil
+		}
+	}
+
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 5 * time.Second,
+			MaxIdleConnsPerHost: 4,
+			IdleConnTimeout:     90 * time.Second,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// deliverCallback POSTs the completed operation to its callback URL, retrying
+// with backoff until it responds with a 2xx status.
+func (s *Gateway) deliverCallback(op *Operation) {
+	b, err := json.Marshal(op)
+	if err != nil {
+		zap.L().Error("unexpected error when encoding operation", zap.String("operation", op.ID), zap.Error(err))
+		return
+	}
+
+	for attempt := 0; attempt < maxCallbackAttempts; attempt++ {
+		if attempt > 0 && !sleep(s.ctx, backoffDelay(callbackBackoff, attempt-1)) {
+			return
+		}
+
+		err = s.postCallback(op.callbackURL, b)
+		if err == nil {
+			return
+		}
+		zap.L().Warn("failed to deliver operation to callback", zap.String("operation", op.ID), zap.String("url", op.callbackURL), zap.Int("attempt", attempt), zap.Error(err))
+	}
+	zap.L().Error("giving up delivering operation to callback", zap.String("operation", op.ID), zap.String("url", op.callbackURL))
+}
+
+func (s *Gateway) postCallback(callbackURL string, b []byte) error {
+	req, err := http.NewRequestWithContext(s.ctx, http.MethodPost, callbackURL, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.callbackClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("callback responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
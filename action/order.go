@@ -0,0 +1,84 @@
+package action
+
+import "sync"
+
+// keySequencer orders the actions which share a partition key, while actions
+// with different keys proceed independently of each other.
+type keySequencer struct {
+	mu   sync.Mutex
+	last map[string]*ticket
+}
+
+// ticket tracks the progress of a single action through the sequencer.
+type ticket struct {
+	queued     chan struct{}
+	queuedOnce sync.Once
+	released   chan struct{}
+}
+
+func newKeySequencer() *keySequencer {
+	return &keySequencer{
+		last: make(map[string]*ticket),
+	}
+}
+
+// next returns a new ticket for the given key, along with the ticket of the
+// action submitted before it, if that action hasn't been released yet.
+func (s *keySequencer) next(key string) (prev, t *ticket) {
+	t = &ticket{
+		queued:   make(chan struct{}),
+		released: make(chan struct{}),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prev = s.last[key]
+	s.last[key] = t
+	return prev, t
+}
+
+// done releases the ticket, unblocking the next action with the same key.
+// An action which gave up before the one submitted before it, prev, was
+// queued or released keeps holding back the next one until prev was, so
+// actions with the same key never overtake each other.
+func (s *keySequencer) done(key string, prev, t *ticket) {
+	if prev == nil || prev.isReleased() {
+		s.release(key, t)
+		return
+	}
+
+	go func() {
+		<-prev.queued
+		t.markQueued()
+		<-prev.released
+		s.release(key, t)
+	}()
+}
+
+func (s *keySequencer) release(key string, t *ticket) {
+	t.markQueued()
+	close(t.released)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.last[key] == t {
+		delete(s.last, key)
+	}
+}
+
+func (t *ticket) isReleased() bool {
+	select {
+	case <-t.released:
+		return true
+	default:
+		return false
+	}
+}
+
+func (t *ticket) markQueued() {
+	t.queuedOnce.Do(func() {
+		close(t.queued)
+	})
+}
@@ -0,0 +1,134 @@
+package action
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+func isClosed(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+func waitClosed(t *testing.T, ch <-chan struct{}, what string) {
+	t.Helper()
+
+	select {
+	case <-ch:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for %s", what)
+	}
+}
+
+func TestKeySequencerDoneAfterCancel(t *testing.T) {
+	testCases := []struct {
+		name string
+
+		// whether the previous action was queued, and so this one, before
+		// this one gave up
+		prevQueued bool
+	}{
+		{name: "waiting on prev queued", prevQueued: false},
+		{name: "waiting on prev released", prevQueued: true},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			s := newKeySequencer()
+
+			_, a := s.next("k")
+			prev, b := s.next("k")
+			if prev != a {
+				t.Fatal("got a different previous ticket than the one submitted before")
+			}
+			if testCase.prevQueued {
+				a.markQueued()
+				b.markQueued()
+			}
+
+			// b gives up, while the action after it is already waiting on it
+			s.done("k", a, b)
+			prev, c := s.next("k")
+			if prev != b {
+				t.Fatal("canceled ticket was forgotten before the one submitted before it was released")
+			}
+			if isClosed(b.queued) != testCase.prevQueued {
+				t.Fatalf("canceled ticket queued %t, want %t", isClosed(b.queued), testCase.prevQueued)
+			}
+
+			a.markQueued()
+			waitClosed(t, b.queued, "the canceled ticket to be queued after prev")
+			if isClosed(b.released) {
+				t.Fatal("canceled ticket was released before prev")
+			}
+
+			s.done("k", nil, a)
+			waitClosed(t, b.released, "the canceled ticket to be released after prev")
+
+			s.done("k", b, c)
+			if !isClosed(c.released) {
+				t.Fatal("last ticket wasn't released right away once prev was")
+			}
+			if n := len(s.last); n != 0 {
+				t.Errorf("got %d keys tracked, want none once every ticket is released", n)
+			}
+		})
+	}
+}
+
+func TestMuxOrderedDeliveryReleasesResponsesInOrder(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p := newFakeProcessor()
+	// requests wait for the stream to be established rather than failing
+	m := NewMux(ctx, p, WithOrderedDelivery(), WithInFlightPolicy(ResendInFlight))
+
+	returned := make(map[string]chan struct{})
+	send := func(name, key string) *ProcessorRequest {
+		returned[name] = make(chan struct{})
+		go func(done chan struct{}) {
+			defer close(done)
+
+			_, err := m.SendAction(ctx, &Action{TypeName: "HELLO", PartitionKey: key})
+			if err != nil {
+				t.Errorf("failed to send %s: %s", name, err)
+			}
+		}(returned[name])
+
+		// submit the next action only once this one reached the processor
+		return p.request(t)
+	}
+	respond := func(req *ProcessorRequest) {
+		p.responses <- &ProcessorResponse{
+			Id:   req.GetId(),
+			Body: &ProcessorResponse_WasProcessed{WasProcessed: new(emptypb.Empty)},
+		}
+	}
+
+	a1 := send("a1", "a")
+	a2 := send("a2", "a")
+	b1 := send("b1", "b")
+
+	// responses to later actions are held back behind earlier ones with the
+	// same key only
+	respond(a2)
+	respond(b1)
+	waitClosed(t, returned["b1"], "b1 to return while a1 is outstanding")
+
+	time.Sleep(50 * time.Millisecond)
+	if isClosed(returned["a2"]) {
+		t.Fatal("a2 returned before a1")
+	}
+
+	respond(a1)
+	waitClosed(t, returned["a1"], "a1 to return")
+	waitClosed(t, returned["a2"], "a2 to return after a1")
+}
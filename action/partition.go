@@ -0,0 +1,52 @@
+package action
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// partitionKeyFromPayload extracts the value at the given dot separated path,
+// e.g. "user.id" or "items.0.sku", from a JSON payload to use as a partition key.
+func partitionKeyFromPayload(payload []byte, path string) (string, error) {
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.UseNumber()
+
+	var v interface{}
+	err := dec.Decode(&v)
+	if err != nil {
+		return "", err
+	}
+
+	for _, field := range strings.Split(path, ".") {
+		switch x := v.(type) {
+		case map[string]interface{}:
+			var ok bool
+			v, ok = x[field]
+			if !ok {
+				return "", fmt.Errorf("payload has no field at path: %s", path)
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(field)
+			if err != nil || i < 0 || i >= len(x) {
+				return "", fmt.Errorf("payload has no element at path: %s", path)
+			}
+			v = x[i]
+		default:
+			return "", fmt.Errorf("payload has no field at path: %s", path)
+		}
+	}
+
+	switch x := v.(type) {
+	case string:
+		return x, nil
+	case json.Number:
+		return x.String(), nil
+	case bool:
+		return strconv.FormatBool(x), nil
+	default:
+		return "", fmt.Errorf("payload value at path must be a string, number or bool: %s", path)
+	}
+}
@@ -0,0 +1,157 @@
+package action
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Zaba505/eventproc/orchestration"
+	"go.uber.org/zap"
+)
+
+// pipelineStep sends the action, or the content of the previous step, to
+// the route of another action type.
+type pipelineStep struct {
+	typeName    string
+	route       *route
+	timeout     time.Duration
+	skipOnError bool
+
+	// compensate is sent to the route of compensateType to undo the step
+	compensateType string
+	compensate     *route
+}
+
+// pipeline chains routes, so the content each step responds with becomes the
+// payload of the next step's action, and only the last content is returned.
+// Pipelines with compensating steps run as sagas, see orchestration.
+type pipeline struct {
+	steps []*pipelineStep
+	saga  bool
+}
+
+// newPipeline compiles the steps of a pipeline against the routes of the
+// table they're sent through.
+func newPipeline(cfgs []StepConfig, routes map[string]*route) *pipeline {
+	p := &pipeline{steps: make([]*pipelineStep, len(cfgs))}
+	for i, cfg := range cfgs {
+		p.steps[i] = &pipelineStep{
+			typeName:    cfg.Type,
+			route:       routes[strings.ToLower(cfg.Type)],
+			timeout:     cfg.Timeout,
+			skipOnError: cfg.OnError == "skip",
+		}
+		if cfg.Compensate != "" {
+			p.steps[i].compensateType = cfg.Compensate
+			p.steps[i].compensate = routes[strings.ToLower(cfg.Compensate)]
+			p.saga = true
+		}
+	}
+	return p
+}
+
+func (p *pipeline) send(ctx context.Context, s *Gateway, act *Action) (*Action, []*PartialFailure, error) {
+	var failures []*PartialFailure
+
+	// last is the response of the last step which succeeded
+	var last *Action
+	var lastErr error
+	processed := false
+
+	steps := make([]orchestration.Step, len(p.steps))
+	for i, step := range p.steps {
+		i, step := i, step
+		steps[i] = orchestration.Step{
+			Name:            fmt.Sprintf("%d (%s)", i, step.typeName),
+			ContinueOnError: step.skipOnError,
+			Do: func(ctx context.Context, payload []byte) ([]byte, error) {
+				resp, stepFailures, err := step.send(ctx, s, newStepAction(step.typeName, payload, act.GetPartitionKey()))
+				failures = append(failures, stepFailures...)
+				if err != nil {
+					err = fmt.Errorf("pipeline step %d (%s): %w", i, step.typeName, err)
+					if step.skipOnError {
+						lastErr = err
+						zap.L().Warn("skipping failed pipeline step", zap.Int("step", i), zap.String("type", step.typeName), zap.Error(err))
+						failures = append(failures, &PartialFailure{
+							Group:  fmt.Sprintf("step %d (%s)", i, step.typeName),
+							Status: newStatus(statusError(err)),
+						})
+					}
+					return nil, err
+				}
+
+				// steps which respond without content pass the payload on as is
+				processed = true
+				last = resp
+				return resp.GetPayload(), nil
+			},
+		}
+		if step.compensate != nil {
+			steps[i].Compensate = func(ctx context.Context, input, output []byte) error {
+				return step.sendCompensation(ctx, s, input, output, act.GetPartitionKey())
+			}
+		}
+	}
+
+	coordinator := s.pipelines
+	if p.saga {
+		coordinator = s.sagas
+	}
+	_, err := coordinator.Run(ctx, steps, act.GetPayload())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !processed {
+		return nil, nil, lastErr
+	}
+	return last, failures, nil
+}
+
+func (step *pipelineStep) send(ctx context.Context, s *Gateway, act *Action) (*Action, []*PartialFailure, error) {
+	if step.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, step.timeout)
+		defer cancel()
+	}
+
+	err := step.route.setPartitionKey(act)
+	if err != nil {
+		return nil, nil, err
+	}
+	return step.route.send(ctx, s, step.typeName, act)
+}
+
+// sendCompensation sends the compensating action of the step, whose payload is
+// what the step responded with, or what it was sent if it responded without.
+func (step *pipelineStep) sendCompensation(ctx context.Context, s *Gateway, input, output []byte, partitionKey string) error {
+	payload := output
+	if payload == nil {
+		payload = input
+	}
+
+	act := newStepAction(step.compensateType, payload, partitionKey)
+	err := step.compensate.setPartitionKey(act)
+	if err != nil {
+		return err
+	}
+
+	_, _, err = step.compensate.send(ctx, s, step.compensateType, act)
+	if err != nil {
+		return fmt.Errorf("compensating action %s: %w", step.compensateType, err)
+	}
+	return nil
+}
+
+func newStepAction(typeName string, payload []byte, partitionKey string) *Action {
+	act := &Action{
+		TypeName:     typeName,
+		Payload:      payload,
+		PartitionKey: partitionKey,
+	}
+	if legacy, ok := Action_Type_value[typeName]; ok {
+		act.Type = Action_Type(legacy)
+	}
+	return act
+}
@@ -0,0 +1,229 @@
+package action
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+)
+
+// MuxPool spreads Actions across multiple Muxes, and therefore multiple
+// processor streams, so throughput isn't capped by a single HTTP/2 stream.
+type MuxPool struct {
+	ctx        context.Context
+	dial       func() (*grpc.ClientConn, error)
+	connPerMux bool
+	muxOpts    []MuxOption
+
+	// next rotates which Mux is considered first, so ties are spread evenly
+	next uint32
+
+	mu     sync.RWMutex
+	cc     *grpc.ClientConn
+	pooled []*pooledMux
+}
+
+type pooledMux struct {
+	mux *Mux
+
+	// cc is only set when the Mux has a dedicated ClientConn
+	cc *grpc.ClientConn
+}
+
+type MuxPoolOption func(*MuxPool)
+
+// WithMuxOptions configures the options every Mux in the pool is created with.
+func WithMuxOptions(opts ...MuxOption) MuxPoolOption {
+	return func(p *MuxPool) {
+		p.muxOpts = append(p.muxOpts, opts...)
+	}
+}
+
+// WithConnPerMux gives every Mux in the pool its own ClientConn, instead of
+// sharing a single one, so their streams don't share an HTTP/2 connection.
+func WithConnPerMux() MuxPoolOption {
+	return func(p *MuxPool) {
+		p.connPerMux = true
+	}
+}
+
+// NewMuxPool returns a MuxPool of the given size whose Muxes open streams on
+// ClientConns created by dial.
+func NewMuxPool(ctx context.Context, dial func() (*grpc.ClientConn, error), size int, opts ...MuxPoolOption) (*MuxPool, error) {
+	p := &MuxPool{
+		ctx:  ctx,
+		dial: dial,
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	err := p.Resize(ctx, size)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// SendAction sends the action over the Mux with the least in-flight actions.
+// When the Muxes deliver actions in order, actions with a partition key are
+// instead always sent over the same Mux so their order is preserved.
+func (p *MuxPool) SendAction(ctx context.Context, act *Action) (*Action, error) {
+	m := p.pick(act.GetPartitionKey())
+	if m == nil {
+		return nil, fmt.Errorf("%w: mux pool is empty", ErrProcessorUnavailable)
+	}
+	return m.SendAction(ctx, act)
+}
+
+func (p *MuxPool) pick(key string) *Mux {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	n := len(p.pooled)
+	if n == 0 {
+		return nil
+	}
+	if key != "" && p.pooled[0].mux.sequencer != nil {
+		return p.pooled[hashKey(key)%uint64(n)].mux
+	}
+
+	start := int(atomic.AddUint32(&p.next, 1))
+	var least *Mux
+	for i := 0; i < n; i++ {
+		m := p.pooled[(start+i)%n].mux
+		if least == nil || m.InFlight() < least.InFlight() {
+			least = m
+		}
+	}
+	return least
+}
+
+// Size returns the number of Muxes in the pool.
+func (p *MuxPool) Size() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return len(p.pooled)
+}
+
+// InFlight returns the number of actions awaiting a response across all Muxes.
+func (p *MuxPool) InFlight() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	var n int
+	for _, pm := range p.pooled {
+		n += pm.mux.InFlight()
+	}
+	return n
+}
+
+// Resize grows or shrinks the pool to the given size. Muxes removed from the
+// pool are closed in the background once their in-flight actions complete.
+// The pool is left as it was if it fails to grow.
+func (p *MuxPool) Resize(ctx context.Context, size int) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var added []*pooledMux
+	for len(p.pooled)+len(added) < size {
+		pm, err := p.newPooledMux()
+		if err != nil {
+			for _, pm := range added {
+				go p.closePooledMux(ctx, pm)
+			}
+			return err
+		}
+		added = append(added, pm)
+	}
+	p.pooled = append(p.pooled, added...)
+
+	if len(p.pooled) > size {
+		removed := p.pooled[size:]
+		p.pooled = p.pooled[:size:size]
+
+		for _, pm := range removed {
+			go p.closePooledMux(ctx, pm)
+		}
+	}
+
+	zap.L().Debug("resized mux pool", zap.Int("size", size))
+	return nil
+}
+
+// Close closes every Mux in the pool, waiting for their in-flight actions to
+// complete or ctx to be done.
+func (p *MuxPool) Close(ctx context.Context) error {
+	p.mu.Lock()
+	removed := p.pooled
+	p.pooled = nil
+	cc := p.cc
+	p.cc = nil
+	p.mu.Unlock()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(removed))
+	for _, pm := range removed {
+		wg.Add(1)
+		go func(pm *pooledMux) {
+			defer wg.Done()
+			errs <- p.closePooledMux(ctx, pm)
+		}(pm)
+	}
+	wg.Wait()
+	close(errs)
+
+	if cc != nil {
+		cc.Close()
+	}
+
+	for err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *MuxPool) newPooledMux() (*pooledMux, error) {
+	if p.connPerMux {
+		cc, err := p.dial()
+		if err != nil {
+			return nil, err
+		}
+
+		return &pooledMux{
+			mux: NewMux(p.ctx, NewProcessorClient(cc), p.muxOpts...),
+			cc:  cc,
+		}, nil
+	}
+
+	if p.cc == nil {
+		cc, err := p.dial()
+		if err != nil {
+			return nil, err
+		}
+		p.cc = cc
+	}
+
+	return &pooledMux{
+		mux: NewMux(p.ctx, NewProcessorClient(p.cc), p.muxOpts...),
+	}, nil
+}
+
+func (p *MuxPool) closePooledMux(ctx context.Context, pm *pooledMux) error {
+	err := pm.mux.Close(ctx)
+	if err != nil {
+		zap.L().Warn("closed mux before its in-flight actions completed", zap.Error(err))
+	}
+
+	if pm.cc != nil {
+		pm.cc.Close()
+	}
+	return err
+}
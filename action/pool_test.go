@@ -0,0 +1,59 @@
+package action
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func TestMuxPoolResizeFailureKeepsPool(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the fourth dial fails, after two Muxes were added by the resize
+	var dialed []*grpc.ClientConn
+	failure := errors.New("dial failed")
+	dial := func() (*grpc.ClientConn, error) {
+		if len(dialed) == 3 {
+			return nil, failure
+		}
+		cc, err := grpc.Dial("127.0.0.1:1", grpc.WithTransportCredentials(insecure.NewCredentials()))
+		if err != nil {
+			return nil, err
+		}
+		dialed = append(dialed, cc)
+		return cc, nil
+	}
+
+	p, err := NewMuxPool(ctx, dial, 1, WithConnPerMux())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close(ctx)
+
+	err = p.Resize(ctx, 4)
+	if !errors.Is(err, failure) {
+		t.Fatalf("got error %v, want %v", err, failure)
+	}
+	if n := p.Size(); n != 1 {
+		t.Fatalf("got pool of size %d, want it left at 1", n)
+	}
+
+	// the ClientConns of the Muxes the resize added are closed with them
+	for i, cc := range dialed[1:] {
+		for deadline := time.Now().Add(5 * time.Second); cc.GetState() != connectivity.Shutdown; {
+			if time.Now().After(deadline) {
+				t.Fatalf("ClientConn %d of the resize is %s, want it shut down", i, cc.GetState())
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	if state := dialed[0].GetState(); state == connectivity.Shutdown {
+		t.Errorf("ClientConn of the pooled Mux is %s", state)
+	}
+}
@@ -0,0 +1,79 @@
+package action
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var typeNamePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_.-]*$`)
+
+// TypeRegistry holds the names of the action types known at runtime.
+// Names are case insensitive, but are always resolved to the casing they
+// were first registered with.
+type TypeRegistry struct {
+	mu    sync.RWMutex
+	names map[string]string
+}
+
+// NewTypeRegistry returns a TypeRegistry with the legacy Action_Type
+// names already registered.
+func NewTypeRegistry() *TypeRegistry {
+	r := &TypeRegistry{
+		names: make(map[string]string),
+	}
+	for name := range Action_Type_value {
+		r.Register(name)
+	}
+	return r
+}
+
+// Register registers the given action type names.
+func (r *TypeRegistry) Register(names ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, name := range names {
+		key := strings.ToLower(name)
+		if _, ok := r.names[key]; !ok {
+			r.names[key] = name
+		}
+	}
+}
+
+// Lookup resolves the given name to its registered name.
+func (r *TypeRegistry) Lookup(name string) (string, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	registered, ok := r.names[strings.ToLower(name)]
+	return registered, ok
+}
+
+// Names returns every registered name in sorted order.
+func (r *TypeRegistry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.names))
+	for _, name := range r.names {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// TypeName returns the name of the action's type, falling back to the
+// name of its legacy Action_Type.
+func TypeName(act *Action) string {
+	if name := act.GetTypeName(); name != "" {
+		return name
+	}
+	return act.GetType().String()
+}
+
+// validTypeName reports whether name can be used as an action type name.
+func validTypeName(name string) bool {
+	return typeNamePattern.MatchString(name)
+}
@@ -0,0 +1,143 @@
+package action
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// defaultRetryOn are the codes of the errors retried unless configured
+// otherwise, which are the ones of transient failures.
+var defaultRetryOn = []codes.Code{codes.Unavailable, codes.ResourceExhausted, codes.DeadlineExceeded}
+
+// retryPolicy retries sending actions which failed to be processed.
+type retryPolicy struct {
+	maxAttempts    int
+	backoff        backoff.Config
+	retryOn        map[codes.Code]bool
+	attemptTimeout time.Duration
+}
+
+// newRetryPolicy returns the retry policy configured by cfg, or nil if
+// actions aren't retried.
+func newRetryPolicy(cfg RetryConfig) *retryPolicy {
+	if cfg.MaxAttempts <= 1 {
+		return nil
+	}
+
+	p := &retryPolicy{
+		maxAttempts: cfg.MaxAttempts,
+		backoff: backoff.Config{
+			BaseDelay:  100 * time.Millisecond,
+			Multiplier: 1.6,
+			Jitter:     0.2,
+			MaxDelay:   5 * time.Second,
+		},
+		retryOn:        make(map[codes.Code]bool),
+		attemptTimeout: cfg.AttemptTimeout,
+	}
+	if cfg.BaseDelay > 0 {
+		p.backoff.BaseDelay = cfg.BaseDelay
+	}
+	if cfg.MaxDelay > 0 {
+		p.backoff.MaxDelay = cfg.MaxDelay
+	}
+	if cfg.Multiplier > 0 {
+		p.backoff.Multiplier = cfg.Multiplier
+	}
+	if cfg.Jitter > 0 {
+		p.backoff.Jitter = cfg.Jitter
+	}
+
+	if len(cfg.RetryOn) == 0 {
+		for _, code := range defaultRetryOn {
+			p.retryOn[code] = true
+		}
+	}
+	// the codes were already validated
+	for _, name := range cfg.RetryOn {
+		code, _ := lookupCode(name)
+		p.retryOn[code] = true
+	}
+	return p
+}
+
+// lookupCode returns the gRPC status code with the given name, which is case
+// and underscore insensitive, e.g. Unavailable or DEADLINE_EXCEEDED.
+func lookupCode(name string) (codes.Code, bool) {
+	name = strings.ReplaceAll(name, "_", "")
+	for code := codes.OK; code <= codes.Unauthenticated; code++ {
+		if strings.EqualFold(code.String(), name) {
+			return code, true
+		}
+	}
+	return codes.Unknown, false
+}
+
+// retryable reports whether an attempt which failed with err is worth
+// retrying. Actions the client canceled never are.
+func (p *retryPolicy) retryable(err error) bool {
+	if errors.Is(err, ErrCanceled) {
+		return false
+	}
+	return p.retryOn[status.Code(statusError(err))]
+}
+
+// send calls f until it succeeds, fails with an error which isn't retryable,
+// or runs out of attempts. Attempts are marked as such, sharing the
+// idempotency key of the delivery ctx is for, if any, and never retried past
+// the deadline of ctx.
+func (p *retryPolicy) send(ctx context.Context, f func(ctx context.Context) (*Action, error)) (*Action, error) {
+	key := deliveryFromContext(ctx).idempotencyKey
+	if key == "" {
+		uid, err := uuid.NewRandom()
+		if err != nil {
+			return nil, err
+		}
+		key = uid.String()
+	}
+
+	for attempt := 1; ; attempt++ {
+		attemptCtx := withDelivery(ctx, delivery{idempotencyKey: key, attempt: uint32(attempt)})
+		cancel := func() {}
+		if p.attemptTimeout > 0 {
+			attemptCtx, cancel = context.WithTimeout(attemptCtx, p.attemptTimeout)
+		}
+
+		resp, err := f(attemptCtx)
+		cancel()
+		if err == nil {
+			return resp, nil
+		}
+
+		if attempt >= p.maxAttempts || ctx.Err() != nil || !p.retryable(err) {
+			return nil, withAttempts(err, attempt)
+		}
+
+		delay := backoffDelay(p.backoff, attempt-1)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return nil, withAttempts(err, attempt)
+		}
+
+		zap.L().Debug("retrying action", zap.Int("attempt", attempt), zap.Duration("delay", delay), zap.Error(err))
+		if !sleep(ctx, delay) {
+			return nil, withAttempts(err, attempt)
+		}
+	}
+}
+
+// withAttempts records how often delivering the action was attempted in err.
+func withAttempts(err error, attempts int) error {
+	var de *deliveryError
+	if errors.As(err, &de) {
+		de.attempts = attempts
+	}
+	return err
+}
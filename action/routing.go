@@ -0,0 +1,534 @@
+package action
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+)
+
+// checkTimeout bounds how long reloads spend checking for unreachable processors.
+const checkTimeout = 5 * time.Second
+
+// RoutingTable is a compiled routing config. It's never modified once
+// compiled, so reloads swap in a whole new table instead.
+type RoutingTable struct {
+	// routes are keyed by lower cased action type name
+	routes map[string]*route
+
+	// registered routes action types without a route to the processors
+	// which registered them.
+	registered *route
+}
+
+func (t *RoutingTable) lookup(typeName string) (*route, bool) {
+	if t == nil {
+		return nil, false
+	}
+
+	r, ok := t.routes[strings.ToLower(typeName)]
+	return r, ok
+}
+
+// route is how actions of a single type are routed.
+type route struct {
+	// the embedded target is the DefaultGroup, which is also in groups
+	*target
+	groups map[string]*target
+
+	partitionKeyPath string
+
+	// fanOut is only set when actions are fanned out to several groups,
+	// split when they're split between groups by weight and shadow when
+	// they're mirrored to a candidate group.
+	fanOut *fanOut
+	split  *split
+	shadow *shadow
+
+	// rules are evaluated in order before any of the above, and pipeline
+	// replaces all of them when set.
+	rules    []*rule
+	pipeline *pipeline
+}
+
+// target is a group of processor endpoints actions can be sent to.
+type target struct {
+	group *EndpointGroup
+
+	// registered targets are routed to the endpoints processors registered
+	// the action type on instead of group. cached holds the *cachedGroup
+	// last built from them by lower cased action type name.
+	registered  bool
+	newBalancer BalancerBuilder
+	cached      sync.Map
+
+	// retry is only set when failed actions are retried
+	retry *retryPolicy
+}
+
+type cachedGroup struct {
+	version uint64
+	group   *EndpointGroup
+}
+
+// endpointGroup returns the endpoints actions of the given type are balanced
+// across, or nil if there are none.
+func (t *target) endpointGroup(reg *processorRegistry, typeName string) *EndpointGroup {
+	if !t.registered {
+		return t.group
+	}
+
+	endpoints, version := reg.endpoints(typeName)
+	if len(endpoints) == 0 {
+		return nil
+	}
+
+	key := strings.ToLower(typeName)
+	if c, ok := t.cached.Load(key); ok && c.(*cachedGroup).version == version {
+		return c.(*cachedGroup).group
+	}
+
+	g := NewEndpointGroup(endpoints, t.newBalancer)
+	t.cached.Store(key, &cachedGroup{version: version, group: g})
+	return g
+}
+
+// sendAction sends the action to one of the target's endpoints.
+func (t *target) sendAction(ctx context.Context, reg *processorRegistry, typeName string, act *Action) (*Action, error) {
+	if key := clientDeliveryKey(ctx, act); key != "" {
+		ctx = withDelivery(ctx, delivery{idempotencyKey: key})
+	}
+	if t.retry == nil {
+		return t.sendAttempt(ctx, reg, typeName, act)
+	}
+	return t.retry.send(ctx, func(ctx context.Context) (*Action, error) {
+		return t.sendAttempt(ctx, reg, typeName, act)
+	})
+}
+
+// sendAttempt sends the action to one of the target's endpoints once, which
+// is picked again for every attempt.
+func (t *target) sendAttempt(ctx context.Context, reg *processorRegistry, typeName string, act *Action) (*Action, error) {
+	g := t.endpointGroup(reg, typeName)
+	if g == nil {
+		return nil, fmt.Errorf("%w: no processors registered for action type", ErrProcessorUnavailable)
+	}
+	return g.SendAction(ctx, act)
+}
+
+// send sends the action to the DefaultGroup or fans it out, returning the
+// groups which failed to process it when it succeeded overall.
+func (r *route) send(ctx context.Context, s *Gateway, typeName string, act *Action) (*Action, []*PartialFailure, error) {
+	if r.pipeline != nil {
+		return r.pipeline.send(ctx, s, act)
+	}
+
+	var primary chan<- primaryResult
+	if r.shadow != nil {
+		primary = r.shadow.mirror(ctx, s, typeName, act)
+	}
+
+	var resp *Action
+	var failures []*PartialFailure
+	var err error
+	group, matched := matchRules(ctx, r.rules, typeName, act)
+	switch {
+	case matched:
+		resp, err = r.groups[group].sendAction(ctx, s.registry, typeName, act)
+	case r.fanOut != nil:
+		resp, failures, err = r.fanOut.send(ctx, s, r, typeName, act)
+	case r.split != nil:
+		resp, err = r.split.send(ctx, s, r, typeName, act)
+	default:
+		resp, err = r.sendAction(ctx, s.registry, typeName, act)
+	}
+
+	if primary != nil {
+		primary <- primaryResult{act: resp, err: err}
+	}
+	return resp, failures, err
+}
+
+// endpointKey identifies processor endpoints which can be shared between
+// routes and reused across reloads. Streams is left out since pools are
+// resized in place instead.
+type endpointKey struct {
+	addr   string
+	stream StreamConfig
+}
+
+type managedEndpoint struct {
+	*Endpoint
+	pool *MuxPool
+
+	// streams is the size the pool is resized to once its config is loaded
+	streams int
+}
+
+// setPartitionKey sets the partition key of the action from its payload, if
+// it doesn't have one and the route has a payload path configured.
+func (r *route) setPartitionKey(act *Action) error {
+	if act.GetPartitionKey() != "" || r.partitionKeyPath == "" {
+		return nil
+	}
+
+	key, err := partitionKeyFromPayload(act.GetPayload(), r.partitionKeyPath)
+	if err != nil {
+		return err
+	}
+	act.PartitionKey = key
+	return nil
+}
+
+// Reload re-reads, validates and compiles the routing config and swaps it in.
+// Endpoints which are no longer routed to are drained in the background, so
+// in-flight actions aren't dropped. The current config is kept on errors.
+func (s *Gateway) Reload() error {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	rc, err := LoadRoutingConfig(s.cfg)
+	if err != nil {
+		return err
+	}
+	err = CheckStorage(rc, s.actionLogDir != "", s.sagaStore != nil)
+	if err != nil {
+		return err
+	}
+	cfgs := rc.Routes
+
+	endpoints := make(map[endpointKey]*managedEndpoint)
+	table := &RoutingTable{
+		routes: make(map[string]*route, len(cfgs)),
+		registered: &route{
+			target: &target{
+				registered:  true,
+				newBalancer: NewRoundRobinBalancer,
+			},
+		},
+	}
+	for name, cfg := range cfgs {
+		r := &route{
+			groups:           make(map[string]*target, len(cfg.Groups)+1),
+			partitionKeyPath: cfg.PartitionKeyPath,
+		}
+
+		r.target, err = s.target(endpoints, cfg.GroupConfig)
+		if err != nil {
+			s.closeUnused(endpoints)
+			return err
+		}
+		r.groups[DefaultGroup] = r.target
+
+		for group, groupCfg := range cfg.Groups {
+			r.groups[strings.ToLower(group)], err = s.target(endpoints, groupCfg)
+			if err != nil {
+				s.closeUnused(endpoints)
+				return err
+			}
+		}
+
+		retry := newRetryPolicy(cfg.Retry)
+		for _, t := range r.groups {
+			t.retry = retry
+		}
+
+		if len(cfg.FanOut.Groups) > 0 {
+			r.fanOut = newFanOut(cfg.FanOut)
+		}
+		r.rules, err = newRules(cfg.Rules)
+		if err != nil {
+			s.closeUnused(endpoints)
+			return err
+		}
+		if len(cfg.Split.Weights) > 0 {
+			r.split = newSplit(cfg.Split)
+		}
+		if cfg.Shadow.Group != "" {
+			r.shadow = newShadow(cfg.Shadow, r.groups)
+		}
+
+		table.routes[strings.ToLower(name)] = r
+	}
+
+	// pipelines are compiled last since their steps are sent through routes
+	for name, cfg := range cfgs {
+		if len(cfg.Pipeline) > 0 {
+			table.routes[strings.ToLower(name)].pipeline = newPipeline(cfg.Pipeline, table.routes)
+		}
+	}
+
+	// processors which are also routed to statically share their endpoint
+processors:
+	for _, addr := range rc.Processors {
+		for key := range endpoints {
+			if key.addr == addr {
+				continue processors
+			}
+		}
+
+		_, err := s.endpoint(endpoints, addr, StreamConfig{})
+		if err != nil {
+			s.closeUnused(endpoints)
+			return err
+		}
+	}
+
+	s.table.Store(table)
+	for name := range cfgs {
+		s.types.Register(name)
+	}
+
+	for _, e := range endpoints {
+		if e.pool.Size() == e.streams {
+			continue
+		}
+		err := e.pool.Resize(s.ctx, e.streams)
+		if err != nil {
+			zap.L().Error("failed to resize processor endpoint", zap.String("addr", e.Addr()), zap.Int("streams", e.streams), zap.Error(err))
+		}
+	}
+
+	for key, e := range s.endpoints {
+		if _, ok := endpoints[key]; !ok {
+			go s.drain(e)
+		}
+	}
+	s.endpoints = endpoints
+
+	zap.L().Info("loaded routing config", zap.Int("routes", len(table.routes)), zap.Int("processors", len(rc.Processors)))
+
+	// processors are only warned about since they're reconnected to and may
+	// be redeployed with support for their action types
+	go func() {
+		ctx, cancel := context.WithTimeout(s.ctx, checkTimeout)
+		defer cancel()
+
+		infos, err := CheckProcessors(ctx, rc, s.dial)
+		if err != nil {
+			zap.L().Warn("routing to misconfigured processors", zap.Error(err))
+		}
+		for _, info := range infos {
+			s.types.Register(info.GetActionTypes()...)
+		}
+	}()
+	return nil
+}
+
+// target returns the target for the group of endpoints, dialing them as
+// needed. Targets without endpoints route to the processors which registered
+// the action type.
+func (s *Gateway) target(endpoints map[endpointKey]*managedEndpoint, cfg GroupConfig) (*target, error) {
+	// the balancer was already validated
+	newBalancer, _ := LookupBalancer(cfg.Balancer)
+
+	if len(cfg.Endpoints) == 0 {
+		return &target{
+			registered:  true,
+			newBalancer: newBalancer,
+		}, nil
+	}
+
+	group := make([]*Endpoint, 0, len(cfg.Endpoints))
+	for _, addr := range cfg.Endpoints {
+		e, err := s.endpoint(endpoints, addr, cfg.Stream)
+		if err != nil {
+			return nil, err
+		}
+		group = append(group, e.Endpoint)
+	}
+
+	return &target{
+		group: NewEndpointGroup(group, newBalancer),
+	}, nil
+}
+
+// routingTable returns the currently loaded RoutingTable.
+func (s *Gateway) routingTable() *RoutingTable {
+	t, _ := s.table.Load().(*RoutingTable)
+	return t
+}
+
+// endpoint returns the endpoint for the given address, reusing the current
+// one if possible and dialing it otherwise.
+func (s *Gateway) endpoint(endpoints map[endpointKey]*managedEndpoint, addr string, cfg StreamConfig) (*managedEndpoint, error) {
+	streams := cfg.Streams
+	if streams < 1 {
+		streams = 1
+	}
+
+	key := endpointKey{addr: addr, stream: cfg}
+	key.stream.Streams = 0
+
+	if e, ok := endpoints[key]; ok {
+		return e, nil
+	}
+
+	// the pools of current endpoints are only resized once the config is
+	// swapped in, so they're left as they are if loading it fails
+	if e, ok := s.endpoints[key]; ok {
+		e = &managedEndpoint{Endpoint: e.Endpoint, pool: e.pool, streams: streams}
+		endpoints[key] = e
+		return e, nil
+	}
+
+	onRegister, setEndpoint := s.registrationHandler()
+
+	opts := append(cfg.poolOptions(), WithMuxOptions(onRegister))
+	if cfg.Durable {
+		l, err := s.actionLog(addr)
+		if err != nil {
+			setEndpoint(nil)
+			return nil, fmt.Errorf("failed to open action log of processor %s: %w", addr, err)
+		}
+		opts = append(opts, WithMuxOptions(WithActionLog(l)))
+	}
+	pool, err := NewMuxPool(s.ctx, func() (*grpc.ClientConn, error) {
+		return s.dial(addr)
+	}, streams, opts...)
+	if err != nil {
+		setEndpoint(nil)
+		return nil, fmt.Errorf("failed to dial processor %s: %w", addr, err)
+	}
+
+	var endpointOpts []EndpointOption
+	if cfg.EjectAfter > 0 {
+		endpointOpts = append(endpointOpts, WithEjection(cfg.EjectAfter, cfg.EjectFor))
+	}
+
+	e := &managedEndpoint{
+		Endpoint: NewEndpoint(addr, pool, endpointOpts...),
+		pool:     pool,
+		streams:  streams,
+	}
+	setEndpoint(e.Endpoint)
+
+	endpoints[key] = e
+	return e, nil
+}
+
+// actionLog returns the log of the actions durably sent to the processor at
+// addr, which stays open until the Gateway is closed so its actions are still
+// redelivered once the processor is routed to again.
+func (s *Gateway) actionLog(addr string) (*ActionLog, error) {
+	if l, ok := s.actionLogs[addr]; ok {
+		return l, nil
+	}
+
+	l, err := OpenActionLog(filepath.Join(s.actionLogDir, url.PathEscape(addr)))
+	if err != nil {
+		return nil, err
+	}
+	s.actionLogs[addr] = l
+	return l, nil
+}
+
+// registrationHandler returns a MuxOption recording the registrations of
+// Muxes in the live registry under the Endpoint they're sent through. Since
+// streams may register before the Endpoint exists, registrations wait for it
+// to be set, and are dropped if it's set to nil.
+func (s *Gateway) registrationHandler() (MuxOption, func(*Endpoint)) {
+	ready := make(chan struct{})
+	var endpoint *Endpoint
+
+	opt := WithRegistrationHandler(func(m *Mux, info *ProcessorInfo) {
+		<-ready
+		if endpoint == nil {
+			return
+		}
+		if info != nil {
+			s.types.Register(info.GetActionTypes()...)
+		}
+		s.registry.update(m, endpoint, info)
+	})
+
+	setEndpoint := func(e *Endpoint) {
+		endpoint = e
+		close(ready)
+	}
+	return opt, setEndpoint
+}
+
+// closeUnused closes the endpoints dialed by a failed reload.
+func (s *Gateway) closeUnused(endpoints map[endpointKey]*managedEndpoint) {
+	for key, e := range endpoints {
+		if _, ok := s.endpoints[key]; !ok {
+			go s.drain(e)
+		}
+	}
+}
+
+func (s *Gateway) drain(e *managedEndpoint) {
+	ctx, cancel := context.WithTimeout(s.ctx, s.drainTimeout)
+	defer cancel()
+
+	err := e.pool.Close(ctx)
+	if err != nil {
+		zap.L().Warn("failed to drain processor endpoint", zap.String("addr", e.Addr()), zap.Error(err))
+		return
+	}
+	zap.L().Info("drained processor endpoint", zap.String("addr", e.Addr()))
+}
+
+// Close drains every processor endpoint, waiting for in-flight actions to
+// complete or ctx to be done.
+func (s *Gateway) Close(ctx context.Context) error {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	s.table.Store(new(RoutingTable))
+	connected := s.disconnect()
+
+	var firstErr error
+	for _, e := range s.endpoints {
+		err := e.pool.Close(ctx)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	s.endpoints = nil
+
+	for _, m := range connected {
+		err := m.Close(ctx)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	for addr, l := range s.actionLogs {
+		err := l.Close()
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(s.actionLogs, addr)
+	}
+	return firstErr
+}
+
+func (cfg StreamConfig) poolOptions() []MuxPoolOption {
+	muxOpts := []MuxOption{}
+	if cfg.ResendInFlight {
+		muxOpts = append(muxOpts, WithInFlightPolicy(ResendInFlight))
+	}
+	if cfg.SendQueueSize > 0 {
+		muxOpts = append(muxOpts, WithSendQueueSize(cfg.SendQueueSize))
+	}
+	if cfg.FailWhenQueueFull {
+		muxOpts = append(muxOpts, WithQueueFullPolicy(FailWhenFull))
+	}
+	if cfg.Ordered {
+		muxOpts = append(muxOpts, WithOrderedDelivery())
+	}
+
+	opts := []MuxPoolOption{WithMuxOptions(muxOpts...)}
+	if cfg.ConnPerStream {
+		opts = append(opts, WithConnPerMux())
+	}
+	return opts
+}
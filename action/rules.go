@@ -0,0 +1,122 @@
+package action
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/google/cel-go/cel"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/metadata"
+)
+
+var (
+	ruleEnvOnce sync.Once
+	ruleEnv     *cel.Env
+	ruleEnvErr  error
+)
+
+// newRuleEnv returns the CEL environment routing rules are compiled in. Rules
+// are evaluated against:
+//
+//	payload       the decoded JSON payload, whose numbers are doubles
+//	typeName      the action type name
+//	partitionKey  the partition key of the action, if any
+//	metadata      the first value of every request metadata key, e.g. HTTP header
+func newRuleEnv() (*cel.Env, error) {
+	ruleEnvOnce.Do(func() {
+		ruleEnv, ruleEnvErr = cel.NewEnv(
+			cel.Variable("payload", cel.DynType),
+			cel.Variable("typeName", cel.StringType),
+			cel.Variable("partitionKey", cel.StringType),
+			cel.Variable("metadata", cel.MapType(cel.StringType, cel.StringType)),
+			cel.CrossTypeNumericComparisons(true),
+		)
+	})
+	return ruleEnv, ruleEnvErr
+}
+
+// compileRule compiles the CEL expression of a routing rule, which has to
+// evaluate to a bool.
+func compileRule(expr string) (cel.Program, error) {
+	env, err := newRuleEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	ast, iss := env.Compile(expr)
+	if iss.Err() != nil {
+		return nil, iss.Err()
+	}
+	if t := ast.OutputType(); t != cel.BoolType && t != cel.DynType {
+		return nil, fmt.Errorf("must evaluate to a bool instead of %s", t)
+	}
+	return env.Program(ast)
+}
+
+// rule routes actions to a group when its expression evaluates to true.
+type rule struct {
+	when    string
+	group   string
+	program cel.Program
+}
+
+func newRules(cfgs []RuleConfig) ([]*rule, error) {
+	rules := make([]*rule, len(cfgs))
+	for i, cfg := range cfgs {
+		prg, err := compileRule(cfg.When)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i, err)
+		}
+
+		rules[i] = &rule{
+			when:    cfg.When,
+			group:   strings.ToLower(cfg.Group),
+			program: prg,
+		}
+	}
+	return rules, nil
+}
+
+// matchRules returns the group of the first rule the action matches. Rules
+// which fail to evaluate, e.g. because of a missing payload field, don't match.
+func matchRules(ctx context.Context, rules []*rule, typeName string, act *Action) (string, bool) {
+	if len(rules) == 0 {
+		return "", false
+	}
+
+	var payload interface{}
+	err := json.Unmarshal(act.GetPayload(), &payload)
+	if err != nil {
+		zap.L().Debug("routing rules can't match non JSON payload", zap.Error(err))
+		return "", false
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	firstValues := make(map[string]string, len(md))
+	for k, vs := range md {
+		if len(vs) > 0 {
+			firstValues[k] = vs[0]
+		}
+	}
+
+	vars := map[string]interface{}{
+		"payload":      payload,
+		"typeName":     typeName,
+		"partitionKey": act.GetPartitionKey(),
+		"metadata":     firstValues,
+	}
+	for _, r := range rules {
+		out, _, err := r.program.Eval(vars)
+		if err != nil {
+			zap.L().Debug("failed to evaluate routing rule", zap.String("when", r.when), zap.Error(err))
+			continue
+		}
+		if matched, ok := out.Value().(bool); ok && matched {
+			return r.group, true
+		}
+	}
+	return "", false
+}
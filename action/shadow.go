@@ -0,0 +1,115 @@
+package action
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math/rand"
+	"reflect"
+	"strings"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// shadow mirrors a share of a route's actions to a candidate group of
+// processors, whose responses never reach clients.
+type shadow struct {
+	group   string
+	target  *target
+	percent float64
+	diff    bool
+}
+
+func newShadow(cfg ShadowConfig, groups map[string]*target) *shadow {
+	group := strings.ToLower(cfg.Group)
+	return &shadow{
+		group:   group,
+		target:  groups[group],
+		percent: cfg.Percent,
+		diff:    cfg.Diff,
+	}
+}
+
+// primaryResult is what the primary responded to a mirrored action with.
+type primaryResult struct {
+	act *Action
+	err error
+}
+
+// mirror sends a sample of the actions to the shadow group in the background.
+// The returned channel, which is nil for actions which aren't mirrored, takes
+// the primary's result so it can be diffed against the shadow's.
+func (sh *shadow) mirror(ctx context.Context, s *Gateway, typeName string, act *Action) chan<- primaryResult {
+	if sh.percent <= 0 || rand.Float64()*100 >= sh.percent {
+		return nil
+	}
+
+	primary := make(chan primaryResult, 1)
+	go func() {
+		ctx, cancel := backgroundContext(s.ctx, ctx)
+		defer cancel()
+
+		resp, err := sh.target.sendAction(ctx, s.registry, typeName, act)
+		if !sh.diff {
+			if err != nil {
+				zap.L().Debug("shadow failed to process action", zap.String("type", typeName), zap.String("group", sh.group), zap.Error(err))
+			}
+			return
+		}
+
+		var p primaryResult
+		select {
+		case <-ctx.Done():
+			return
+		case p = <-primary:
+		}
+
+		fields := []zap.Field{zap.String("type", typeName), zap.String("group", sh.group)}
+		if diff := diffResults(p, primaryResult{act: resp, err: err}); diff != "" {
+			zap.L().Warn("shadow response differs from primary", append(fields, zap.String("diff", diff))...)
+			return
+		}
+		zap.L().Debug("shadow response matches primary", fields...)
+	}()
+	return primary
+}
+
+// diffResults describes how the shadow's result differs from the primary's,
+// or returns the empty string if it doesn't.
+func diffResults(primary, shadow primaryResult) string {
+	switch {
+	case primary.err != nil || shadow.err != nil:
+		p, sh := resultCode(primary.err), resultCode(shadow.err)
+		if p != sh {
+			return "primary responded with " + p.String() + " but shadow with " + sh.String()
+		}
+		return ""
+	case !equalContent(primary.act.GetPayload(), shadow.act.GetPayload()):
+		return "primary responded with " + string(primary.act.GetPayload()) + " but shadow with " + string(shadow.act.GetPayload())
+	default:
+		return ""
+	}
+}
+
+func resultCode(err error) codes.Code {
+	if err == nil {
+		return codes.OK
+	}
+	return status.Code(statusError(err))
+}
+
+// equalContent compares JSON content semantically, e.g. ignoring key order,
+// and everything else byte by byte.
+func equalContent(a, b []byte) bool {
+	if bytes.Equal(a, b) {
+		return true
+	}
+
+	var x, y interface{}
+	if json.Unmarshal(a, &x) != nil || json.Unmarshal(b, &y) != nil {
+		return false
+	}
+	return reflect.DeepEqual(x, y)
+}
@@ -0,0 +1,123 @@
+package action
+
+import (
+	"context"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// split splits a route's actions between its groups by weight. The weights
+// can be changed at runtime, see Gateway.SetWeights, until the next reload.
+type split struct {
+	sticky bool
+
+	// weights holds the current *weightTable
+	weights atomic.Value
+}
+
+// weightTable maps ranges of [0, total) onto groups, so a group is picked by
+// the range a random, or hashed, number falls into.
+type weightTable struct {
+	groups     []string
+	cumulative []int
+	total      int
+}
+
+func newSplit(cfg SplitConfig) *split {
+	sp := &split{sticky: cfg.Sticky}
+	sp.weights.Store(newWeightTable(cfg.Weights))
+	return sp
+}
+
+func newWeightTable(weights map[string]int) *weightTable {
+	t := new(weightTable)
+	for _, group := range sortedWeightNames(weights) {
+		w := weights[group]
+		if w <= 0 {
+			continue
+		}
+
+		t.total += w
+		t.groups = append(t.groups, strings.ToLower(group))
+		t.cumulative = append(t.cumulative, t.total)
+	}
+	return t
+}
+
+func (t *weightTable) pick(n int) string {
+	i := sort.SearchInts(t.cumulative, n+1)
+	return t.groups[i]
+}
+
+func (t *weightTable) byGroup() map[string]int {
+	weights := make(map[string]int, len(t.groups))
+	prev := 0
+	for i, group := range t.groups {
+		weights[group] = t.cumulative[i] - prev
+		prev = t.cumulative[i]
+	}
+	return weights
+}
+
+// pick returns the name of the group to send an action with the given
+// partition key to.
+func (sp *split) pick(key string) string {
+	t := sp.weights.Load().(*weightTable)
+	if sp.sticky && key != "" {
+		return t.pick(int(hashKey(key) % uint64(t.total)))
+	}
+	return t.pick(rand.Intn(t.total))
+}
+
+func (sp *split) send(ctx context.Context, s *Gateway, r *route, typeName string, act *Action) (*Action, error) {
+	group := sp.pick(act.GetPartitionKey())
+	return r.groups[group].sendAction(ctx, s.registry, typeName, act)
+}
+
+// Weights returns how the actions of the given type are currently split
+// between the groups of its route.
+func (s *Gateway) Weights(typeName string) (map[string]int, error) {
+	r, ok := s.routingTable().lookup(typeName)
+	if !ok || r.split == nil {
+		return nil, status.Errorf(codes.NotFound, "actions of type %s aren't split", typeName)
+	}
+	return r.split.weights.Load().(*weightTable).byGroup(), nil
+}
+
+// SetWeights changes how the actions of the given type are split between the
+// groups of its route, until the routing config is next reloaded.
+func (s *Gateway) SetWeights(typeName string, weights map[string]int) error {
+	r, ok := s.routingTable().lookup(typeName)
+	if !ok || r.split == nil {
+		return status.Errorf(codes.NotFound, "actions of type %s aren't split", typeName)
+	}
+
+	if len(weights) == 0 {
+		return status.Error(codes.InvalidArgument, "no split weights given")
+	}
+
+	lowered := make(map[string]int, len(weights))
+	for group, w := range weights {
+		lowered[strings.ToLower(group)] = w
+	}
+
+	groups := make(map[string]GroupConfig, len(r.groups))
+	for group := range r.groups {
+		groups[group] = GroupConfig{}
+	}
+	if errs := validateWeights(lowered, groups); len(errs) > 0 {
+		msgs := make([]string, len(errs))
+		for i, err := range errs {
+			msgs[i] = err.Error()
+		}
+		return status.Error(codes.InvalidArgument, strings.Join(msgs, "; "))
+	}
+
+	r.split.weights.Store(newWeightTable(lowered))
+	return nil
+}
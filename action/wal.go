@@ -0,0 +1,414 @@
+package action
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	walSuffix = ".wal"
+
+	// records are a header of the body's length and CRC-32 followed by the
+	// body, whose first byte is the kind of record
+	walHeaderSize = 8
+
+	walAppend byte = 1
+	walAck    byte = 2
+)
+
+// ActionLog is a durable log of the actions sent to a processor which it
+// hasn't responded to yet, so they can be redelivered after the gateway
+// crashed. It's split into segment files, which are deleted once every
+// action logged in them, and in the segments before them, was acknowledged.
+// An ActionLog is safe for concurrent use.
+type ActionLog struct {
+	dir            string
+	maxSegmentSize int64
+
+	mu         sync.Mutex
+	segments   []*logSegment
+	active     *os.File
+	activeSize int64
+	entries    map[string]*logEntry
+	seq        uint64
+	closed     bool
+}
+
+type logSegment struct {
+	index   uint64
+	path    string
+	unacked int
+}
+
+type logEntry struct {
+	act *Action
+	seg *logSegment
+	seq uint64
+
+	// attempts at delivering the action so far, at least as far as known
+	attempts uint32
+
+	// claimed entries are being delivered, so mustn't be redelivered
+	claimed bool
+}
+
+type ActionLogOption func(*ActionLog)
+
+// WithMaxSegmentSize configures the size in bytes after which a new segment
+// file is started. The default is 64MiB.
+func WithMaxSegmentSize(size int64) ActionLogOption {
+	return func(l *ActionLog) {
+		l.maxSegmentSize = size
+	}
+}
+
+// OpenActionLog opens the log in dir, creating it if needed. Actions which
+// were logged but never acknowledged, e.g. because the gateway crashed, are
+// redelivered by the Muxes using the log, see WithActionLog.
+func OpenActionLog(dir string, opts ...ActionLogOption) (*ActionLog, error) {
+	l := &ActionLog{
+		dir:            dir,
+		maxSegmentSize: 64 << 20,
+		entries:        make(map[string]*logEntry),
+	}
+
+	for _, opt := range opts {
+		opt(l)
+	}
+
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	indexes, err := l.segmentIndexes()
+	if err != nil {
+		return nil, err
+	}
+
+	next := uint64(1)
+	for _, index := range indexes {
+		seg := &logSegment{index: index, path: l.segmentPath(index)}
+		err = l.replay(seg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to replay action log segment %s: %w", seg.path, err)
+		}
+		l.segments = append(l.segments, seg)
+		next = index + 1
+	}
+
+	// never append to a segment a crash may have left a torn record in
+	err = l.startSegment(next)
+	if err != nil {
+		return nil, err
+	}
+	l.truncate()
+
+	if n := len(l.entries); n > 0 {
+		zap.L().Info("recovered unacknowledged actions", zap.String("dir", dir), zap.Int("actions", n))
+	}
+	return l, nil
+}
+
+// Len returns the number of actions awaiting acknowledgement.
+func (l *ActionLog) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return len(l.entries)
+}
+
+// Close closes the log, keeping the unacknowledged actions for the next time
+// it's opened.
+func (l *ActionLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+	return l.active.Close()
+}
+
+// append durably logs the action as claimed by its sender. Actions which
+// are already logged, e.g. because they're retried, are only claimed.
+func (l *ActionLog) append(key string, act *Action) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return errors.New("action log is closed")
+	}
+	if e, ok := l.entries[key]; ok {
+		// redeliveries already claimed the action
+		if !e.claimed {
+			e.claimed = true
+			e.attempts++
+		}
+		return nil
+	}
+
+	data, err := proto.Marshal(&ProcessorRequest{IdempotencyKey: key, Action: act})
+	if err != nil {
+		return err
+	}
+
+	err = l.write(walAppend, data)
+	if err != nil {
+		return err
+	}
+	err = l.active.Sync()
+	if err != nil {
+		return err
+	}
+
+	seg := l.segments[len(l.segments)-1]
+	seg.unacked++
+	l.seq++
+	l.entries[key] = &logEntry{act: act, seg: seg, seq: l.seq, attempts: 1, claimed: true}
+	return nil
+}
+
+// ack forgets the action once the processor responded to it. Acks aren't
+// synced, since losing one merely redelivers the action.
+func (l *ActionLog) ack(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e, ok := l.entries[key]
+	if !ok {
+		return
+	}
+	delete(l.entries, key)
+	e.seg.unacked--
+
+	if l.closed {
+		return
+	}
+	err := l.write(walAck, []byte(key))
+	if err != nil {
+		zap.L().Warn("failed to log action acknowledgement", zap.String("key", key), zap.Error(err))
+	}
+	l.truncate()
+}
+
+// release makes an action which failed to be delivered available for
+// redelivery.
+func (l *ActionLog) release(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if e, ok := l.entries[key]; ok {
+		e.claimed = false
+	}
+}
+
+// redeliveryError is returned for actions which failed to be delivered, but
+// are left in the ActionLog to be redelivered.
+type redeliveryError struct {
+	err error
+}
+
+func (e *redeliveryError) Error() string {
+	return e.err.Error()
+}
+
+func (e *redeliveryError) Unwrap() error {
+	return e.err
+}
+
+type claimedAction struct {
+	key     string
+	act     *Action
+	attempt uint32
+}
+
+// claim returns up to max unacknowledged actions which nobody is delivering,
+// oldest first, for redelivery.
+func (l *ActionLog) claim(max int) []claimedAction {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var claimed []claimedAction
+	for key, e := range l.entries {
+		if e.claimed {
+			continue
+		}
+		claimed = append(claimed, claimedAction{key: key, act: e.act})
+	}
+	sort.Slice(claimed, func(i, j int) bool {
+		return l.entries[claimed[i].key].seq < l.entries[claimed[j].key].seq
+	})
+	if len(claimed) > max {
+		claimed = claimed[:max]
+	}
+
+	for i, c := range claimed {
+		e := l.entries[c.key]
+		e.claimed = true
+		e.attempts++
+		claimed[i].attempt = e.attempts
+	}
+	return claimed
+}
+
+// write appends a record to the active segment, starting a new one first
+// if it's full.
+func (l *ActionLog) write(kind byte, data []byte) error {
+	if l.activeSize >= l.maxSegmentSize {
+		err := l.active.Close()
+		if err != nil {
+			return err
+		}
+		err = l.startSegment(l.segments[len(l.segments)-1].index + 1)
+		if err != nil {
+			return err
+		}
+	}
+
+	body := append([]byte{kind}, data...)
+	rec := make([]byte, walHeaderSize+len(body))
+	binary.BigEndian.PutUint32(rec[0:4], uint32(len(body)))
+	binary.BigEndian.PutUint32(rec[4:8], crc32.ChecksumIEEE(body))
+	copy(rec[walHeaderSize:], body)
+
+	n, err := l.active.Write(rec)
+	l.activeSize += int64(n)
+	return err
+}
+
+func (l *ActionLog) startSegment(index uint64) error {
+	seg := &logSegment{index: index, path: l.segmentPath(index)}
+	f, err := os.OpenFile(seg.path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+
+	err = syncDir(l.dir)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	l.segments = append(l.segments, seg)
+	l.active = f
+	l.activeSize = 0
+	return nil
+}
+
+// truncate deletes the oldest segments as long as all their actions were
+// acknowledged. Segments are only deleted in order, so acknowledgements are
+// never deleted before the actions they acknowledge.
+func (l *ActionLog) truncate() {
+	for len(l.segments) > 1 && l.segments[0].unacked == 0 {
+		seg := l.segments[0]
+		err := os.Remove(seg.path)
+		if err != nil {
+			zap.L().Warn("failed to delete action log segment", zap.String("path", seg.path), zap.Error(err))
+			return
+		}
+		l.segments = l.segments[1:]
+	}
+}
+
+// replay reads the records of a segment, stopping at the first torn or
+// corrupt one, which can only be the last one written before a crash.
+func (l *ActionLog) replay(seg *logSegment) error {
+	f, err := os.Open(seg.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	header := make([]byte, walHeaderSize)
+	for {
+		_, err = io.ReadFull(r, header)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			zap.L().Warn("ignoring torn action log record", zap.String("path", seg.path), zap.Error(err))
+			return nil
+		}
+
+		body := make([]byte, binary.BigEndian.Uint32(header[0:4]))
+		_, err = io.ReadFull(r, body)
+		if err != nil || len(body) == 0 || crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(header[4:8]) {
+			zap.L().Warn("ignoring torn action log record", zap.String("path", seg.path), zap.Error(err))
+			return nil
+		}
+
+		switch body[0] {
+		case walAppend:
+			req := new(ProcessorRequest)
+			err = proto.Unmarshal(body[1:], req)
+			if err != nil {
+				return err
+			}
+			if _, ok := l.entries[req.GetIdempotencyKey()]; ok {
+				continue
+			}
+			seg.unacked++
+			l.seq++
+			l.entries[req.GetIdempotencyKey()] = &logEntry{act: req.GetAction(), seg: seg, seq: l.seq, attempts: 1}
+		case walAck:
+			if e, ok := l.entries[string(body[1:])]; ok {
+				delete(l.entries, string(body[1:]))
+				e.seg.unacked--
+			}
+		default:
+			return fmt.Errorf("unknown record kind %d", body[0])
+		}
+	}
+}
+
+func (l *ActionLog) segmentIndexes() ([]uint64, error) {
+	des, err := os.ReadDir(l.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var indexes []uint64
+	for _, de := range des {
+		name := de.Name()
+		if de.IsDir() || !strings.HasSuffix(name, walSuffix) {
+			continue
+		}
+		index, err := strconv.ParseUint(strings.TrimSuffix(name, walSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		indexes = append(indexes, index)
+	}
+	sort.Slice(indexes, func(i, j int) bool { return indexes[i] < indexes[j] })
+	return indexes, nil
+}
+
+func (l *ActionLog) segmentPath(index uint64) string {
+	return filepath.Join(l.dir, fmt.Sprintf("%020d%s", index, walSuffix))
+}
+
+// syncDir makes the creation of files in dir durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,247 @@
+package action
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+func openTestLog(t *testing.T, dir string, opts ...ActionLogOption) *ActionLog {
+	t.Helper()
+
+	l, err := OpenActionLog(dir, opts...)
+	if err != nil {
+		t.Fatalf("failed to open action log: %s", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+func appendTestActions(t *testing.T, l *ActionLog, keys ...string) {
+	t.Helper()
+
+	for _, key := range keys {
+		err := l.append(key, &Action{TypeName: "HELLO", Payload: []byte(`"` + key + `"`)})
+		if err != nil {
+			t.Fatalf("failed to append %s: %s", key, err)
+		}
+	}
+}
+
+func recoveredKeys(l *ActionLog) []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	keys := make([]string, 0, len(l.entries))
+	for key := range l.entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func segmentCount(t *testing.T, dir string) int {
+	t.Helper()
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+walSuffix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(paths)
+}
+
+func assertKeys(t *testing.T, got []string, want ...string) {
+	t.Helper()
+
+	if len(got) != len(want) {
+		t.Fatalf("got keys %v, want %v", got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("got keys %v, want %v", got, want)
+		}
+	}
+}
+
+func TestActionLogRecoversUnackedActions(t *testing.T) {
+	dir := t.TempDir()
+
+	l := openTestLog(t, dir)
+	appendTestActions(t, l, "a", "b", "c")
+	l.ack("b")
+	l.Close()
+
+	l = openTestLog(t, dir)
+	assertKeys(t, recoveredKeys(l), "a", "c")
+
+	e := l.entries["c"]
+	if string(e.act.GetPayload()) != `"c"` || e.act.GetTypeName() != "HELLO" {
+		t.Errorf("recovered action %v, want the one appended", e.act)
+	}
+	if e.claimed {
+		t.Error("recovered action is claimed, want it up for redelivery")
+	}
+}
+
+func TestActionLogIgnoresTornRecord(t *testing.T) {
+	dir := t.TempDir()
+
+	l := openTestLog(t, dir)
+	appendTestActions(t, l, "a", "b")
+	l.Close()
+
+	// cut the last record short, as if the gateway crashed writing it
+	path := l.segmentPath(1)
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Truncate(path, fi.Size()-3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l = openTestLog(t, dir)
+	assertKeys(t, recoveredKeys(l), "a")
+
+	// new records go to a new segment, after the torn one
+	appendTestActions(t, l, "c")
+	l.Close()
+
+	l = openTestLog(t, dir)
+	assertKeys(t, recoveredKeys(l), "a", "c")
+}
+
+func TestActionLogIgnoresCorruptRecord(t *testing.T) {
+	dir := t.TempDir()
+
+	l := openTestLog(t, dir)
+	appendTestActions(t, l, "a", "b")
+	l.Close()
+
+	b, err := os.ReadFile(l.segmentPath(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b[len(b)-1] ^= 0xff
+	err = os.WriteFile(l.segmentPath(1), b, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l = openTestLog(t, dir)
+	assertKeys(t, recoveredKeys(l), "a")
+}
+
+func TestActionLogRotatesAndTruncatesSegments(t *testing.T) {
+	dir := t.TempDir()
+
+	// every record starts a new segment
+	l := openTestLog(t, dir, WithMaxSegmentSize(1))
+
+	keys := make([]string, 5)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+	}
+	appendTestActions(t, l, keys...)
+	if n := segmentCount(t, dir); n != 5 {
+		t.Fatalf("got %d segments, want 5", n)
+	}
+
+	// acks of later actions keep the segments of earlier, unacked ones
+	for _, key := range keys[1:] {
+		l.ack(key)
+	}
+	if n := segmentCount(t, dir); n < 5 {
+		t.Fatalf("got %d segments, want the ones from the unacked action's on kept", n)
+	}
+	l.Close()
+
+	l = openTestLog(t, dir, WithMaxSegmentSize(1))
+	assertKeys(t, recoveredKeys(l), "0")
+
+	// once the oldest action is acked, every segment but the active one goes
+	l.ack("0")
+	if n := segmentCount(t, dir); n != 1 {
+		t.Fatalf("got %d segments, want only the active one", n)
+	}
+	l.Close()
+
+	l = openTestLog(t, dir, WithMaxSegmentSize(1))
+	assertKeys(t, recoveredKeys(l))
+}
+
+func TestActionLogAckBeforeAppendAcrossSegments(t *testing.T) {
+	dir := t.TempDir()
+
+	l := openTestLog(t, dir, WithMaxSegmentSize(1))
+	appendTestActions(t, l, "a", "b")
+
+	// the ack of a, in a later segment than a, must never outlive a's append
+	// nor be lost while it's kept
+	l.ack("a")
+	appendTestActions(t, l, "c")
+	l.Close()
+
+	l = openTestLog(t, dir, WithMaxSegmentSize(1))
+	assertKeys(t, recoveredKeys(l), "b", "c")
+}
+
+func TestActionLogClaimAndRelease(t *testing.T) {
+	l := openTestLog(t, t.TempDir())
+	appendTestActions(t, l, "a", "b")
+
+	// appended actions are claimed by their sender
+	if claimed := l.claim(10); len(claimed) != 0 {
+		t.Fatalf("claimed %d actions being delivered, want none", len(claimed))
+	}
+
+	l.release("b")
+	l.release("a")
+	claimed := l.claim(1)
+	if len(claimed) != 1 || claimed[0].key != "a" || claimed[0].attempt != 2 {
+		t.Fatalf("claimed %+v, want the oldest action a as attempt 2", claimed)
+	}
+
+	claimed = l.claim(10)
+	if len(claimed) != 1 || claimed[0].key != "b" {
+		t.Fatalf("claimed %+v, want only b", claimed)
+	}
+
+	// retries of claimed actions don't count as another attempt
+	appendTestActions(t, l, "b")
+	if got := l.entries["b"].attempts; got != 2 {
+		t.Errorf("got %d attempts, want 2", got)
+	}
+
+	l.ack("a")
+	l.release("b")
+	appendTestActions(t, l, "b")
+	if got := l.entries["b"].attempts; got != 3 {
+		t.Errorf("got %d attempts, want 3", got)
+	}
+	if n := l.Len(); n != 1 {
+		t.Errorf("got %d unacked actions, want 1", n)
+	}
+}
+
+func TestActionLogRecoveredActionsAreRedelivered(t *testing.T) {
+	dir := t.TempDir()
+
+	l := openTestLog(t, dir)
+	appendTestActions(t, l, "a", "b", "c")
+	l.Close()
+
+	l = openTestLog(t, dir)
+	claimed := l.claim(10)
+	if len(claimed) != 3 {
+		t.Fatalf("claimed %d actions, want 3", len(claimed))
+	}
+	for i, key := range []string{"a", "b", "c"} {
+		if claimed[i].key != key {
+			t.Fatalf("claimed %s at %d, want actions in the order they were logged", claimed[i].key, i)
+		}
+	}
+}
@@ -1,18 +1,95 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"time"
+
 	"github.com/Zaba505/eventproc/action"
 
 	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
 )
 
 // echoProcessor implements the action.ProcessorServer interface
 // and simply echoes back any action content streamed to it.
 type echoProcessor struct {
 	action.UnimplementedProcessorServer
+
+	actionTypes    []string
+	maxConcurrency uint32
+}
+
+func (p *echoProcessor) info() *action.ProcessorInfo {
+	return &action.ProcessorInfo{
+		ActionTypes:    p.actionTypes,
+		Name:           "echo",
+		Version:        version,
+		MaxConcurrency: p.maxConcurrency,
+	}
+}
+
+func (p *echoProcessor) Describe(context.Context, *emptypb.Empty) (*action.ProcessorInfo, error) {
+	return p.info(), nil
+}
+
+// stream is either a stream opened by the gateway or by the processor,
+// see connect, which are used the same way.
+type stream interface {
+	Send(*action.ProcessorResponse) error
+	Recv() (*action.ProcessorRequest, error)
 }
 
 func (p *echoProcessor) ProcessActions(stream action.Processor_ProcessActionsServer) error {
+	return p.process(stream)
+}
+
+// connect opens a stream to the gateway at addr, authenticating with token,
+// and processes the actions streamed over it, until ctx is done. Terminated
+// streams are reopened.
+func (p *echoProcessor) connect(ctx context.Context, addr, token string) error {
+	cc, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return err
+	}
+	defer cc.Close()
+
+	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+
+	client := action.NewProcessorGatewayClient(cc)
+	for {
+		stream, err := client.Connect(ctx)
+		if err == nil {
+			zap.L().Info("connected to gateway", zap.String("addr", addr))
+			err = p.process(stream)
+		}
+		zap.L().Error("gateway stream terminated", zap.Error(err))
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func (p *echoProcessor) process(stream stream) error {
+	// register with the gateway before any responses are sent
+	err := stream.Send(&action.ProcessorResponse{
+		Body: &action.ProcessorResponse_Registration{
+			Registration: p.info(),
+		},
+	})
+	if err != nil {
+		zap.L().Error("unexpected error when registering with gateway", zap.Error(err))
+		return err
+	}
+
 	for {
 		req, err := stream.Recv()
 		if err != nil {
@@ -20,23 +97,31 @@ func (p *echoProcessor) ProcessActions(stream action.Processor_ProcessActionsSer
 			return err
 		}
 
-		zap.L().Debug("action received", zap.String("id", req.GetId()))
+		zap.L().Debug("action received", zap.String("id", req.GetId()), zap.String("idempotencyKey", req.GetIdempotencyKey()), zap.Uint32("attempt", req.GetAttempt()))
 		go p.sendResponse(stream, req)
 	}
 }
 
-func (p *echoProcessor) sendResponse(stream action.Processor_ProcessActionsServer, req *action.ProcessorRequest) {
+func (p *echoProcessor) sendResponse(stream stream, req *action.ProcessorRequest) {
 	act := req.GetAction()
 	if act == nil {
 		act = new(action.Action)
 	}
 
-	err := stream.Send(&action.ProcessorResponse{
+	resp := &action.ProcessorResponse{
 		Id: req.GetId(),
 		Body: &action.ProcessorResponse_Content{
 			Content: act.GetPayload(),
 		},
-	})
+	}
+
+	// there's nothing to echo back so reject the action
+	payload := act.GetPayload()
+	if len(payload) == 0 || bytes.Equal(payload, []byte("null")) {
+		resp = action.NewErrorResponse(req.GetId(), status.Error(codes.InvalidArgument, "payload must not be empty"))
+	}
+
+	err := stream.Send(resp)
 	if err != nil {
 		zap.L().Error("unexpected error when sending response", zap.Error(err))
 		return
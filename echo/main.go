@@ -6,6 +6,7 @@ import (
 	"net"
 	"os"
 	"os/signal"
+	"strings"
 
 	"github.com/Zaba505/eventproc/action"
 
@@ -14,9 +15,22 @@ import (
 	"google.golang.org/grpc"
 )
 
+// version is reported when registering with the gateway
+const version = "v0.1.0"
+
+var addr string
+var gatewayAddr string
+var gatewayToken string
+var actionTypes string
+var maxConcurrency uint
 var logLevel zapcore.Level
 
 func init() {
+	flag.StringVar(&addr, "addr", ":12345", "address to serve the processor on")
+	flag.StringVar(&gatewayAddr, "gateway", "", "address of a gateway's processor gateway to connect to, instead of serving the processor")
+	flag.StringVar(&gatewayToken, "token", os.Getenv("PROCESSOR_TOKEN"), "bearer token to authenticate with when connecting to a gateway, by default $PROCESSOR_TOKEN")
+	flag.StringVar(&actionTypes, "types", "HELLO", "comma separated list of the action type names to declare as handled")
+	flag.UintVar(&maxConcurrency, "max-concurrency", 0, "max number of actions to process at once per stream, 0 for no limit")
 	flag.Var(&logLevel, "log-level", "Set log level")
 	flag.Parse()
 }
@@ -29,18 +43,33 @@ func main() {
 
 	defer zap.ReplaceGlobals(logger)()
 
-	srv := grpc.NewServer()
-	action.RegisterProcessorServer(srv, new(echoProcessor))
+	p := &echoProcessor{
+		actionTypes:    strings.Split(actionTypes, ","),
+		maxConcurrency: uint32(maxConcurrency),
+	}
 
 	pctx := context.Background()
 	ctx, stop := signal.NotifyContext(pctx, os.Interrupt)
 	defer stop()
 
+	// processors which can't be dialed connect to the gateway instead
+	if gatewayAddr != "" {
+		err = p.connect(ctx, gatewayAddr, gatewayToken)
+		if err != nil {
+			zap.L().Error("unexpected error when connecting to gateway", zap.Error(err))
+			os.Exit(1)
+		}
+		return
+	}
+
+	srv := grpc.NewServer()
+	action.RegisterProcessorServer(srv, p)
+
 	errChan := make(chan error, 1)
 	go func() {
 		defer close(errChan)
 
-		ls, err := net.Listen("tcp", ":12345")
+		ls, err := net.Listen("tcp", addr)
 		if err != nil {
 			errChan <- err
 			return
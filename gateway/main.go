@@ -2,15 +2,21 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
+	"fmt"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
+	"time"
 
 	"github.com/Zaba505/eventproc/action"
+	"github.com/Zaba505/eventproc/orchestration"
 
 	"github.com/fasthttp/router"
+	"github.com/fsnotify/fsnotify"
 	"github.com/gorilla/mux"
 	"github.com/spf13/viper"
 	"github.com/valyala/fasthttp"
@@ -20,21 +26,95 @@ import (
 	"google.golang.org/grpc/credentials/insecure"
 )
 
-var processorAddr string
+var configFile string
+var processorAddrs string
+var balancerName string
+var partitionKeyPath string
+var resendInFlight bool
+var orderedDelivery bool
+var sendQueueSize int
+var failWhenQueueFull bool
+var streamsPerProcessor int
+var connPerStream bool
+var checkTimeout time.Duration
+var processorGatewayAddr string
+var processorToken string
+var processorTypes string
+var sagaDir string
+var sagaRetention time.Duration
+var durable bool
+var walDir string
+var operationTTL time.Duration
+var asyncTimeout time.Duration
+var callbackHosts string
+var idempotencyTTL time.Duration
+var deadLetterDir string
+var adminAddr string
+var maxAttempts int
+var attemptTimeout time.Duration
 var logLevel zapcore.Level
 
 func init() {
-	flag.StringVar(&processorAddr, "processor", ":12345", "specify the event processor service address")
+	flag.StringVar(&configFile, "config", "", "routing config file which is reloaded on changes, instead of routing HELLO actions per the other flags")
+	flag.StringVar(&processorAddrs, "processor", ":12345", "specify a comma separated list of event processor service addresses")
+	flag.StringVar(&balancerName, "balancer", "round_robin", "how actions are balanced across processors: round_robin, least_loaded, power_of_two or consistent_hash")
+	flag.StringVar(&partitionKeyPath, "partition-key-path", "", "dot separated path of the payload field to partition HELLO actions by")
+	flag.BoolVar(&resendInFlight, "resend-in-flight", false, "resend in-flight actions after reconnecting to a processor instead of failing them")
+	flag.BoolVar(&orderedDelivery, "ordered", false, "deliver actions sharing a partition key to processors in order")
+	flag.IntVar(&sendQueueSize, "send-queue-size", 1024, "max number of actions queued up to be sent to a processor")
+	flag.BoolVar(&failWhenQueueFull, "fail-when-queue-full", false, "fail actions instead of waiting when a processor's send queue is full")
+	flag.IntVar(&streamsPerProcessor, "streams", 1, "number of streams opened to each processor")
+	flag.BoolVar(&connPerStream, "conn-per-stream", false, "open every processor stream on its own connection")
+	flag.DurationVar(&checkTimeout, "check-timeout", 5*time.Second, "how long validate-config waits for processors to be reachable")
+	flag.StringVar(&processorGatewayAddr, "processor-gateway-addr", ":9091", "address processors which can't be dialed connect to the gateway on")
+	flag.StringVar(&processorToken, "processor-token", os.Getenv("PROCESSOR_TOKEN"), "bearer token processors connecting to the gateway authenticate with, which they can't unless set, by default $PROCESSOR_TOKEN")
+	flag.StringVar(&processorTypes, "processor-types", "", "comma separated list of the only action types connecting processors may register, instead of any")
+	flag.BoolVar(&durable, "durable", false, "log actions to disk until processors respond to them, redelivering them after restarts")
+	flag.StringVar(&walDir, "wal-dir", "", "directory actions are logged in by durable streams, which is required by them and must survive restarts")
+	flag.StringVar(&sagaDir, "saga-dir", "", "directory the state of pipelines with compensating steps is recorded in, which is required by them")
+	flag.DurationVar(&sagaRetention, "saga-retention", 7*24*time.Hour, "how long the records of sagas which completed or were compensated are kept in -saga-dir")
+	flag.DurationVar(&operationTTL, "operation-ttl", time.Hour, "how long the outcome of an action submitted with Prefer: respond-async is kept once done")
+	flag.DurationVar(&asyncTimeout, "async-timeout", 10*time.Minute, "how long actions submitted with Prefer: respond-async may take")
+	flag.StringVar(&callbackHosts, "callback-hosts", "", "comma separated list of the only hosts, private ones included, results of async actions may be POSTed to, instead of any public host")
+	flag.DurationVar(&idempotencyTTL, "idempotency-ttl", 24*time.Hour, "how long responses to actions sent with an Idempotency-Key are replayed for")
+	flag.IntVar(&maxAttempts, "max-attempts", 1, "max number of attempts at processing HELLO actions, including the first one")
+	flag.DurationVar(&attemptTimeout, "attempt-timeout", 0, "how long each attempt at processing a HELLO action may take, 0 for no limit")
+	flag.StringVar(&deadLetterDir, "dead-letter-dir", "", "directory actions which failed to be processed are stored in, which they aren't unless set")
+	flag.StringVar(&adminAddr, "admin-addr", "", "address the admin API is served on, e.g. localhost:8082, which is disabled unless set")
 	flag.Var(&logLevel, "log-level", "Set log level")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] [validate-config]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
-	if processorAddr == "" {
+	if configFile != "" {
+		viper.SetConfigFile(configFile)
+		return
+	}
+
+	if processorAddrs == "" {
 		panic("must provide an address for a backend event processor to stream incoming events to.")
 	}
 
-	// map HELLO event types to the provided processor address
-	viper.Set(action.TypeToProcessorMapKey, map[action.Action_Type]string{
-		action.Action_HELLO: processorAddr,
+	// map HELLO event types to the provided processor addresses
+	viper.Set(action.RoutesKey, map[string]interface{}{
+		action.Action_HELLO.String(): map[string]interface{}{
+			"endpoints":         strings.Split(processorAddrs, ","),
+			"balancer":          balancerName,
+			"partitionKeyPath":  partitionKeyPath,
+			"streams":           streamsPerProcessor,
+			"connPerStream":     connPerStream,
+			"ordered":           orderedDelivery,
+			"resendInFlight":    resendInFlight,
+			"sendQueueSize":     sendQueueSize,
+			"failWhenQueueFull": failWhenQueueFull,
+			"durable":           durable,
+			"retry": map[string]interface{}{
+				"maxAttempts":    maxAttempts,
+				"attemptTimeout": attemptTimeout,
+			},
+		},
 	})
 }
 
@@ -51,29 +131,96 @@ func main() {
 	ctx, stop := signal.NotifyContext(pctx, os.Interrupt)
 	defer stop()
 
-	// dial a gRPC based Processor backend given its address.
-	client, err := dialEventProcessor(processorAddr)
-	if err != nil {
-		zap.L().Error("unexpected error when dialing event processor backend", zap.Error(err))
-		return
+	if configFile != "" {
+		err = viper.ReadInConfig()
+		if err != nil {
+			zap.L().Error("unexpected error when reading routing config", zap.Error(err))
+			os.Exit(1)
+		}
 	}
 
-	// activate gRPC stream with backend Processor
-	processor, err := client.ProcessActions(ctx)
+	if cmd := flag.Arg(0); cmd != "" {
+		if cmd != "validate-config" {
+			flag.Usage()
+			os.Exit(2)
+		}
+		os.Exit(validateConfig(ctx))
+	}
+
+	// processors outlive ctx so in-flight actions can complete during shutdown
+	procCtx, cancelProcs := context.WithCancel(pctx)
+	defer cancelProcs()
+
+	opts := []action.GatewayOption{
+		action.WithDialer(dialEventProcessor),
+		action.WithOperationTTL(operationTTL),
+		action.WithAsyncTimeout(asyncTimeout),
+		action.WithIdempotencyTTL(idempotencyTTL),
+	}
+	if callbackHosts != "" {
+		opts = append(opts, action.WithCallbackHosts(strings.Split(callbackHosts, ",")...))
+	}
+	if processorToken != "" {
+		var types []string
+		if processorTypes != "" {
+			types = strings.Split(processorTypes, ",")
+		}
+		opts = append(opts, action.WithConnectAuth(processorToken, types...))
+	}
+	if walDir != "" {
+		opts = append(opts, action.WithActionLogDir(walDir))
+	}
+	if deadLetterDir != "" {
+		store, err := action.NewFileDeadLetterStore(deadLetterDir)
+		if err != nil {
+			zap.L().Error("unexpected error when opening dead letter store", zap.Error(err))
+			return
+		}
+		opts = append(opts, action.WithDeadLetterStore(store))
+	}
+	if sagaDir != "" {
+		store, err := orchestration.NewFileStore(sagaDir)
+		if err != nil {
+			zap.L().Error("unexpected error when opening saga store", zap.Error(err))
+			return
+		}
+		go store.Retain(procCtx, sagaRetention)
+		opts = append(opts, action.WithSagaStore(store))
+	}
+
+	// construct EventSink which lies at the heart of the main program
+	s, err := action.NewGateway(procCtx, viper.GetViper(), opts...)
 	if err != nil {
-		zap.L().Error("unexpected error when calling event processor", zap.Error(err))
+		zap.L().Error("unexpected error when loading routing config", zap.Error(err))
 		return
 	}
 
-	// construct EventSink which lies at the heart of the main program
-	s := action.NewGateway(viper.GetViper(), map[string]*action.Mux{
-		processorAddr: action.NewMux(processor),
-	})
+	// swap in routing config changes without restarting
+	if configFile != "" {
+		viper.OnConfigChange(func(e fsnotify.Event) {
+			zap.L().Info("routing config changed", zap.String("file", e.Name))
+
+			err := s.Reload()
+			if err != nil {
+				zap.L().Error("unexpected error when reloading routing config, keeping previous config", zap.Error(err))
+			}
+		})
+		viper.WatchConfig()
+	}
 
 	// fire up standard library HTTP server
 	httpServer := buildActionHTTPGatewayServer(s)
 	httpErrChan := startHTTPServer(ctx, httpServer)
 
+	// fire up admin HTTP server, if enabled
+	var adminServer *http.Server
+	adminErrChan := make(<-chan error)
+	if adminAddr != "" {
+		adminServer = buildAdminServer(s)
+		adminErrChan = startHTTPServer(ctx, adminServer)
+		defer adminServer.Shutdown(pctx)
+	}
+
 	// fire up fasthttp HTTP server
 	fastHttpServer := buildFastHTTPServer(s)
 	fastErrChan := startFastHTTPServer(fastHttpServer)
@@ -81,7 +228,13 @@ func main() {
 	// fire up gRPC server
 	grpcServer := grpc.NewServer()
 	action.RegisterGatewayServer(grpcServer, s)
-	grpcErrChan := startGRPCServer(grpcServer)
+	grpcErrChan := startGRPCServer(grpcServer, ":9090")
+
+	// fire up gRPC server processors connect to, which is only stopped once
+	// their in-flight actions complete
+	procServer := grpc.NewServer()
+	action.RegisterProcessorGatewayServer(procServer, s)
+	procErrChan := startGRPCServer(procServer, processorGatewayAddr)
 
 	select {
 	case <-ctx.Done():
@@ -104,21 +257,70 @@ func main() {
 		stop()
 		httpServer.Shutdown(pctx)
 		grpcServer.GracefulStop()
+	case err := <-adminErrChan:
+		zap.L().Error("received unexpected error from admin http server", zap.Error(err))
+		stop()
+		httpServer.Shutdown(pctx)
+		grpcServer.GracefulStop()
+		fastHttpServer.Shutdown()
+	case err := <-procErrChan:
+		zap.L().Error("received unexpected error from processor grpc server", zap.Error(err))
+		stop()
+		httpServer.Shutdown(pctx)
+		grpcServer.GracefulStop()
+		fastHttpServer.Shutdown()
 	}
 
 	// make sure both gRPC and HTTP server goroutine are done executing
 	<-httpErrChan
 	<-grpcErrChan
-}
 
-// dial a gRPC based EventProcessor backend given its address.
-func dialEventProcessor(addr string) (action.ProcessorClient, error) {
-	cc, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	// give in-flight actions a chance to complete before disconnecting from processors
+	closeCtx, cancel := context.WithTimeout(pctx, 10*time.Second)
+	defer cancel()
+
+	err = s.Close(closeCtx)
 	if err != nil {
-		return nil, err
+		zap.L().Error("unexpected error when disconnecting from processors", zap.Error(err))
+	}
+
+	procServer.Stop()
+	<-procErrChan
+}
+
+// validate the routing config, and that its processors are reachable,
+// returning the exit code
+func validateConfig(ctx context.Context) int {
+	cfgs, err := action.LoadRoutingConfig(viper.GetViper())
+	if err == nil {
+		err = action.CheckStorage(cfgs, walDir != "", sagaDir != "")
+	}
+	if err == nil {
+		ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+		defer cancel()
+
+		_, err = action.CheckProcessors(ctx, cfgs, dialEventProcessor)
+	}
+
+	var cfgErr *action.ConfigError
+	switch {
+	case errors.As(err, &cfgErr):
+		for _, problem := range cfgErr.Problems {
+			fmt.Fprintln(os.Stderr, problem)
+		}
+		return 1
+	case err != nil:
+		fmt.Fprintln(os.Stderr, err)
+		return 1
 	}
 
-	return action.NewProcessorClient(cc), nil
+	fmt.Println("routing config is valid")
+	return 0
+}
+
+// dial a gRPC based EventProcessor backend given its address.
+func dialEventProcessor(addr string) (*grpc.ClientConn, error) {
+	return grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
 }
 
 // build REST style API around action.Gateway
@@ -131,6 +333,10 @@ func buildActionHTTPGatewayServer(s *action.Gateway) *http.Server {
 		Path("/action").
 		Handler(handler)
 
+	router.
+		PathPrefix("/operations").
+		Handler(action.NewOperationsHandler(s))
+
 	srv := &http.Server{
 		Addr:    ":8080",
 		Handler: router,
@@ -139,6 +345,20 @@ func buildActionHTTPGatewayServer(s *action.Gateway) *http.Server {
 	return srv
 }
 
+// build admin API around action.Gateway, which is served apart from the
+// client facing APIs since it's unauthenticated
+func buildAdminServer(s *action.Gateway) *http.Server {
+	router := mux.NewRouter()
+	router.
+		PathPrefix("/admin/").
+		Handler(action.NewAdminHandler(s))
+
+	return &http.Server{
+		Addr:    adminAddr,
+		Handler: router,
+	}
+}
+
 // start http server concurrently
 func startHTTPServer(ctx context.Context, srv *http.Server) <-chan error {
 	errChan := make(chan error, 1)
@@ -183,13 +403,13 @@ func startFastHTTPServer(srv *fasthttp.Server) <-chan error {
 }
 
 // start grpc server concurrently
-func startGRPCServer(srv *grpc.Server) <-chan error {
+func startGRPCServer(srv *grpc.Server, addr string) <-chan error {
 	errChan := make(chan error, 1)
 
 	go func() {
 		defer close(errChan)
 
-		ls, err := net.Listen("tcp", ":9090")
+		ls, err := net.Listen("tcp", addr)
 		if err != nil {
 			errChan <- err
 			return
@@ -0,0 +1,201 @@
+// Package orchestration runs sagas, sequences of steps which are compensated
+// for in reverse order when one of them fails. Steps work on opaque payloads,
+// so sagas can be built on top of any transport.
+package orchestration
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// Step of a saga.
+type Step struct {
+	Name string
+
+	// Do performs the step with the payload, returning the payload of the
+	// next step, or nil to pass the payload on as is.
+	Do func(ctx context.Context, payload []byte) ([]byte, error)
+
+	// Compensate, if set, undoes the step given the payload it was performed
+	// with and what it returned.
+	Compensate func(ctx context.Context, input, output []byte) error
+
+	// ContinueOnError skips the step when it fails, instead of failing the saga.
+	ContinueOnError bool
+}
+
+// Coordinator runs sagas and records their state in a Store.
+type Coordinator struct {
+	ctx                 context.Context
+	store               Store
+	compensationTimeout time.Duration
+}
+
+type Option func(*Coordinator)
+
+// WithStore configures where the state of sagas is recorded. By default
+// it isn't recorded at all.
+func WithStore(store Store) Option {
+	return func(c *Coordinator) {
+		c.store = store
+	}
+}
+
+// WithCompensationTimeout configures how long compensating a failed saga may
+// take. The default is 30s.
+func WithCompensationTimeout(d time.Duration) Option {
+	return func(c *Coordinator) {
+		c.compensationTimeout = d
+	}
+}
+
+// NewCoordinator returns a Coordinator whose compensations run until ctx is
+// done, regardless of the context of the saga which failed.
+func NewCoordinator(ctx context.Context, opts ...Option) *Coordinator {
+	c := &Coordinator{
+		ctx:                 ctx,
+		compensationTimeout: 30 * time.Second,
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// Error is a saga failing at one of its steps.
+type Error struct {
+	SagaID string
+	Step   string
+	Err    error
+
+	// Compensated reports whether every completed step was compensated for
+	Compensated bool
+}
+
+func (e *Error) Error() string {
+	outcome := "compensated"
+	if !e.Compensated {
+		outcome = "compensation failed"
+	}
+	return fmt.Sprintf("saga %s failed at step %s (%s): %v", e.SagaID, e.Step, outcome, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Run runs the steps as a saga starting with the payload, and returns what
+// the last step which didn't fail returned. When a step fails, the steps
+// completed before it are compensated for in reverse order.
+func (c *Coordinator) Run(ctx context.Context, steps []Step, payload []byte) ([]byte, error) {
+	uid, err := uuid.NewRandom()
+	if err != nil {
+		return nil, err
+	}
+
+	rec := &Record{
+		ID:        uid.String(),
+		Status:    Running,
+		Steps:     make([]StepRecord, len(steps)),
+		StartedAt: time.Now(),
+	}
+	for i, step := range steps {
+		rec.Steps[i] = StepRecord{Name: step.Name, Status: StepPending}
+	}
+	c.save(rec)
+
+	var output []byte
+	for i, step := range steps {
+		sr := &rec.Steps[i]
+		sr.Input = payload
+
+		out, err := step.Do(ctx, payload)
+		if err != nil {
+			sr.Error = err.Error()
+			if step.ContinueOnError {
+				sr.Status = StepSkipped
+				c.save(rec)
+				continue
+			}
+
+			sr.Status = StepFailed
+			rec.Error = err.Error()
+			return nil, c.compensate(rec, steps, i, err)
+		}
+
+		sr.Status = StepDone
+		sr.Output = out
+		c.save(rec)
+
+		output = out
+		if out != nil {
+			payload = out
+		}
+	}
+
+	rec.Status = Completed
+	c.save(rec)
+	return output, nil
+}
+
+// compensate compensates for the steps completed before the failed one, in
+// reverse order.
+func (c *Coordinator) compensate(rec *Record, steps []Step, failed int, err error) error {
+	sagaErr := &Error{
+		SagaID:      rec.ID,
+		Step:        steps[failed].Name,
+		Err:         err,
+		Compensated: true,
+	}
+
+	rec.Status = Compensating
+	c.save(rec)
+
+	ctx, cancel := context.WithTimeout(c.ctx, c.compensationTimeout)
+	defer cancel()
+
+	for i := failed - 1; i >= 0; i-- {
+		sr := &rec.Steps[i]
+		if sr.Status != StepDone || steps[i].Compensate == nil {
+			continue
+		}
+
+		err := steps[i].Compensate(ctx, sr.Input, sr.Output)
+		if err != nil {
+			zap.L().Error("failed to compensate saga step", zap.String("saga", rec.ID), zap.String("step", sr.Name), zap.Error(err))
+			sr.Status = StepCompensationFailed
+			sr.Error = err.Error()
+			sagaErr.Compensated = false
+		} else {
+			sr.Status = StepCompensated
+		}
+		c.save(rec)
+	}
+
+	rec.Status = Compensated
+	if !sagaErr.Compensated {
+		rec.Status = CompensationFailed
+	}
+	c.save(rec)
+	return sagaErr
+}
+
+// save records the state of the saga, which is only logged on failure since
+// a saga shouldn't fail just because its state can't be recorded.
+func (c *Coordinator) save(rec *Record) {
+	if c.store == nil {
+		return
+	}
+
+	rec.UpdatedAt = time.Now()
+	err := c.store.Save(c.ctx, rec)
+	if err != nil {
+		zap.L().Warn("failed to record saga state", zap.String("saga", rec.ID), zap.Error(err))
+	}
+}
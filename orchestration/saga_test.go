@@ -0,0 +1,222 @@
+package orchestration
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// recordingStep returns a step which records when it's done and compensated
+// in calls, failing with err if set.
+func recordingStep(name string, calls *[]string, err error) Step {
+	return Step{
+		Name: name,
+		Do: func(_ context.Context, payload []byte) ([]byte, error) {
+			*calls = append(*calls, "do "+name)
+			if err != nil {
+				return nil, err
+			}
+			return append(payload, name...), nil
+		},
+		Compensate: func(_ context.Context, input, output []byte) error {
+			*calls = append(*calls, "compensate "+name+" "+string(input)+"->"+string(output))
+			return nil
+		},
+	}
+}
+
+func loadOnlyRecord(t *testing.T, store *FileStore) *Record {
+	t.Helper()
+
+	paths, err := filepath.Glob(filepath.Join(store.dir, "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 1 {
+		t.Fatalf("got %d saga records, want 1", len(paths))
+	}
+
+	id := filepath.Base(paths[0])
+	rec, err := store.Load(context.Background(), id[:len(id)-len(".json")])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rec
+}
+
+func TestRunCompensatesCompletedStepsInReverse(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewCoordinator(context.Background(), WithStore(store))
+
+	var calls []string
+	failure := errors.New("out of stock")
+	steps := []Step{
+		recordingStep("a", &calls, nil),
+		{
+			Name: "skipped",
+			Do: func(context.Context, []byte) ([]byte, error) {
+				calls = append(calls, "do skipped")
+				return nil, errors.New("optional")
+			},
+			Compensate: func(context.Context, []byte, []byte) error {
+				calls = append(calls, "compensate skipped")
+				return nil
+			},
+			ContinueOnError: true,
+		},
+		recordingStep("b", &calls, nil),
+		recordingStep("c", &calls, failure),
+		recordingStep("d", &calls, nil),
+	}
+
+	_, err = c.Run(context.Background(), steps, []byte("x"))
+
+	var sagaErr *Error
+	if !errors.As(err, &sagaErr) {
+		t.Fatalf("got error %v, want a saga error", err)
+	}
+	if sagaErr.Step != "c" || !sagaErr.Compensated || !errors.Is(err, failure) {
+		t.Errorf("got saga error %+v, want step c failing with %v and compensated", sagaErr, failure)
+	}
+
+	want := []string{
+		"do a",
+		"do skipped",
+		"do b",
+		"do c",
+		"compensate b xa->xab",
+		"compensate a x->xa",
+	}
+	if len(calls) != len(want) {
+		t.Fatalf("got calls %q, want %q", calls, want)
+	}
+	for i := range want {
+		if calls[i] != want[i] {
+			t.Fatalf("got calls %q, want %q", calls, want)
+		}
+	}
+
+	rec := loadOnlyRecord(t, store)
+	if rec.ID != sagaErr.SagaID || rec.Status != Compensated {
+		t.Errorf("got record %s %s, want %s compensated", rec.ID, rec.Status, sagaErr.SagaID)
+	}
+	wantSteps := []StepStatus{StepCompensated, StepSkipped, StepCompensated, StepFailed, StepPending}
+	for i, sr := range rec.Steps {
+		if sr.Status != wantSteps[i] {
+			t.Errorf("got step %s %s, want %s", sr.Name, sr.Status, wantSteps[i])
+		}
+	}
+}
+
+func TestRunRecordsFailedCompensation(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewCoordinator(context.Background(), WithStore(store))
+
+	var calls []string
+	a := recordingStep("a", &calls, nil)
+	a.Compensate = func(context.Context, []byte, []byte) error {
+		return errors.New("refund failed")
+	}
+	steps := []Step{a, recordingStep("b", &calls, errors.New("payment declined"))}
+
+	_, err = c.Run(context.Background(), steps, nil)
+
+	var sagaErr *Error
+	if !errors.As(err, &sagaErr) || sagaErr.Compensated {
+		t.Fatalf("got error %v, want a saga error which wasn't compensated", err)
+	}
+
+	rec := loadOnlyRecord(t, store)
+	if rec.Status != CompensationFailed || rec.Steps[0].Status != StepCompensationFailed || rec.Steps[0].Error != "refund failed" {
+		t.Errorf("got record %+v, want its compensation failed at step a", rec)
+	}
+}
+
+func TestRunCompletes(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewCoordinator(context.Background(), WithStore(store))
+
+	var calls []string
+	steps := []Step{recordingStep("a", &calls, nil), recordingStep("b", &calls, nil)}
+
+	out, err := c.Run(context.Background(), steps, []byte("x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "xab" {
+		t.Errorf("got output %q, want xab", out)
+	}
+	if rec := loadOnlyRecord(t, store); rec.Status != Completed {
+		t.Errorf("got status %s, want completed", rec.Status)
+	}
+}
+
+func TestFileStorePrunesFinishedSagas(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	old := time.Now().Add(-time.Hour)
+	for id, status := range map[string]Status{
+		"completed":           Completed,
+		"compensated":         Compensated,
+		"running":             Running,
+		"compensating":        Compensating,
+		"compensation_failed": CompensationFailed,
+	} {
+		err = store.Save(ctx, &Record{ID: id, Status: status, UpdatedAt: old})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = store.Save(ctx, &Record{ID: "recent", Status: Completed, UpdatedAt: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := store.Prune(ctx, time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("pruned %d records, want 2", n)
+	}
+
+	for _, id := range []string{"completed", "compensated"} {
+		if _, err := store.Load(ctx, id); !errors.Is(err, ErrNotFound) {
+			t.Errorf("got %v loading pruned record %s, want ErrNotFound", err, id)
+		}
+	}
+	for _, id := range []string{"running", "compensating", "compensation_failed", "recent"} {
+		if _, err := store.Load(ctx, id); err != nil {
+			t.Errorf("got %v loading kept record %s", err, id)
+		}
+	}
+
+	// temporary files of records being saved are left alone
+	tmp, err := os.CreateTemp(store.dir, "saving.*.tmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmp.Close()
+	if _, err := store.Prune(ctx, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(tmp.Name()); err != nil {
+		t.Errorf("temporary file was pruned: %v", err)
+	}
+}
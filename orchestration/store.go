@@ -0,0 +1,192 @@
+package orchestration
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ErrNotFound is returned by a Store for unknown sagas.
+var ErrNotFound = errors.New("saga not found")
+
+// Status of a saga.
+type Status string
+
+const (
+	Running            Status = "running"
+	Completed          Status = "completed"
+	Compensating       Status = "compensating"
+	Compensated        Status = "compensated"
+	CompensationFailed Status = "compensation_failed"
+)
+
+// StepStatus is the status of a single step of a saga.
+type StepStatus string
+
+const (
+	StepPending            StepStatus = "pending"
+	StepDone               StepStatus = "done"
+	StepSkipped            StepStatus = "skipped"
+	StepFailed             StepStatus = "failed"
+	StepCompensated        StepStatus = "compensated"
+	StepCompensationFailed StepStatus = "compensation_failed"
+)
+
+// Record is the state of a saga.
+type Record struct {
+	ID        string       `json:"id"`
+	Status    Status       `json:"status"`
+	Steps     []StepRecord `json:"steps"`
+	Error     string       `json:"error,omitempty"`
+	StartedAt time.Time    `json:"startedAt"`
+	UpdatedAt time.Time    `json:"updatedAt"`
+}
+
+// StepRecord is the state of a single step of a saga.
+type StepRecord struct {
+	Name   string     `json:"name"`
+	Status StepStatus `json:"status"`
+	Input  []byte     `json:"input,omitempty"`
+	Output []byte     `json:"output,omitempty"`
+	Error  string     `json:"error,omitempty"`
+}
+
+// Store records the state of sagas.
+type Store interface {
+	// Save creates or replaces the record of a saga.
+	Save(ctx context.Context, rec *Record) error
+
+	// Load returns the record of a saga, or ErrNotFound.
+	Load(ctx context.Context, id string) (*Record, error)
+}
+
+// FileStore stores every saga as a JSON file in a directory. Records are
+// kept until pruned, see Prune and Retain.
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore returns a FileStore storing sagas in dir, creating it if needed.
+func NewFileStore(dir string) (*FileStore, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+// Save writes the record to a temporary file first and renames it, so a
+// crash never leaves a partially written record behind.
+func (s *FileStore) Save(_ context.Context, rec *Record) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(s.dir, rec.ID+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.Write(b)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), s.path(rec.ID))
+}
+
+func (s *FileStore) Load(_ context.Context, id string) (*Record, error) {
+	b, err := ioutil.ReadFile(s.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rec := new(Record)
+	err = json.Unmarshal(b, rec)
+	if err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// Prune deletes the records of the sagas which finished, be it completed or
+// compensated, before the given time, returning how many were deleted. The
+// records of sagas which are still running, or failed to be compensated, are
+// kept for operators to look into.
+func (s *FileStore) Prune(ctx context.Context, before time.Time) (int, error) {
+	paths, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return 0, err
+	}
+
+	pruned := 0
+	for _, path := range paths {
+		rec, err := s.Load(ctx, strings.TrimSuffix(filepath.Base(path), ".json"))
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return pruned, err
+		}
+		finished := rec.Status == Completed || rec.Status == Compensated
+		if !finished || !rec.UpdatedAt.Before(before) {
+			continue
+		}
+
+		err = os.Remove(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return pruned, err
+		}
+		pruned++
+	}
+	return pruned, nil
+}
+
+// Retain prunes the records of sagas retention after they finished, checking
+// every retention/2, but at most every minute, until ctx is done.
+func (s *FileStore) Retain(ctx context.Context, retention time.Duration) {
+	interval := retention / 2
+	if interval < time.Minute {
+		interval = time.Minute
+	}
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			n, err := s.Prune(ctx, now.Add(-retention))
+			if err != nil {
+				zap.L().Warn("failed to prune saga records", zap.String("dir", s.dir), zap.Error(err))
+				continue
+			}
+			if n > 0 {
+				zap.L().Debug("pruned saga records", zap.String("dir", s.dir), zap.Int("count", n))
+			}
+		}
+	}
+}
+
+func (s *FileStore) path(id string) string {
+	return filepath.Join(s.dir, filepath.Base(id)+".json")
+}